@@ -0,0 +1,114 @@
+// Copyright 2017-2023 Block, Inc.
+
+package rce
+
+import (
+	"errors"
+	"io/ioutil"
+
+	"github.com/square/rce-agent/cmd"
+	"gopkg.in/yaml.v2"
+)
+
+// ErrInvalidACL is returned by LoadACL and ACL.Validate if a rule has no
+// clients, an invalid client identity, or no commands or tags.
+var ErrInvalidACL = errors.New("invalid ACL rule")
+
+// ACL is an access control list of client identities and the commands they are
+// allowed to run. A client can run a command if any rule allows it. If
+// ServerConfig.ACL is set, clients without a verified TLS identity cannot run
+// any commands. See LoadACL.
+type ACL []ACLRule
+
+// ACLRule allows clients to run commands by name or tag (Spec.Tags). Clients
+// are client identity strings like "cn:ci-runner" or "spiffe://example.org/ci";
+// see Identity.Matches. Command "*" matches all commands.
+type ACLRule struct {
+	Clients  []string `yaml:"clients"`
+	Commands []string `yaml:"commands"`
+	Tags     []string `yaml:"tags"`
+}
+
+type aclFile struct {
+	Access ACL `yaml:"access"`
+}
+
+// LoadACL loads an ACL from a YAML file. The file can be the commands file
+// (see cmd.LoadCommands) or a separate file. The file structure is:
+//
+//	---
+//	access:
+//	  - clients: [cn:oncall, dns:admin.example.com]
+//	    commands: ["*"]
+//	  - clients: [spiffe://example.org/ci]
+//	    commands: [deploy]
+//	    tags: [read-only]
+//
+// If the file has no access rules, the ACL is nil.
+func LoadACL(file string) (ACL, error) {
+	bytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var f aclFile
+	if err := yaml.Unmarshal(bytes, &f); err != nil {
+		return nil, err
+	}
+
+	if len(f.Access) == 0 {
+		return nil, nil
+	}
+
+	if err := f.Access.Validate(); err != nil {
+		return nil, err
+	}
+
+	return f.Access, nil
+}
+
+// Validate returns ErrInvalidACL if any rule is invalid.
+func (a ACL) Validate() error {
+	for _, rule := range a {
+		if len(rule.Clients) == 0 || len(rule.Commands)+len(rule.Tags) == 0 {
+			return ErrInvalidACL
+		}
+		for _, client := range rule.Clients {
+			if !validClient(client) {
+				return ErrInvalidACL
+			}
+		}
+	}
+	return nil
+}
+
+// Allowed returns true if any rule allows the client identity to run the
+// command. It returns false if the identity is nil.
+func (a ACL) Allowed(id *Identity, spec cmd.Spec) bool {
+	if id == nil {
+		return false
+	}
+	for _, rule := range a {
+		if !rule.matchClient(id) {
+			continue
+		}
+		if contains(rule.Commands, "*") || contains(rule.Commands, spec.Name) {
+			return true
+		}
+		for _, tag := range spec.Tags {
+			if contains(rule.Tags, tag) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (r ACLRule) matchClient(id *Identity) bool {
+	for _, client := range r.Clients {
+		if id.Matches(client) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+// Copyright 2017-2023 Block, Inc.
+
+package rce
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/square/rce-agent/cmd"
+	"github.com/square/rce-agent/pb"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+// AuditRecord is one server RPC call. The server makes one record for every
+// call when ServerConfig.Audit is set.
+type AuditRecord struct {
+	Time      time.Time `json:"time"`                 // when the call returned
+	Method    string    `json:"method"`               // RPC method, like Start, Wait, or History
+	Peer      string    `json:"peer"`                 // client address
+	Identity  *Identity `json:"identity,omitempty"`   // client identity, nil if not TLS
+	CommandId string    `json:"command_id,omitempty"` // command ID, if any
+	Command   string    `json:"command,omitempty"`    // command name, if any
+	Args      []string  `json:"args,omitempty"`       // final command args, if any
+	Code      string    `json:"code"`                 // gRPC status code of the call, like "OK"
+	Error     string    `json:"error,omitempty"`      // error message if Code is not OK
+	State     string    `json:"state,omitempty"`      // command state, for Wait and GetStatus
+	ExitCode  *int64    `json:"exit_code,omitempty"`  // command exit code, if it has finished
+	Signal    string    `json:"signal,omitempty"`     // signal that terminated the command, if any
+}
+
+// AuditSink receives audit records from the server. It must be safe for
+// concurrent use. If Audit returns an error, the server logs it.
+type AuditSink interface {
+	Audit(AuditRecord) error
+}
+
+type jsonAuditSink struct {
+	*sync.Mutex
+	enc *json.Encoder
+}
+
+// NewJSONAuditSink returns an AuditSink that writes records to w as JSON lines:
+// one JSON object per line. Each record is one w.Write call.
+func NewJSONAuditSink(w io.Writer) AuditSink {
+	return &jsonAuditSink{
+		Mutex: &sync.Mutex{},
+		enc:   json.NewEncoder(w),
+	}
+}
+
+func (s *jsonAuditSink) Audit(r AuditRecord) error {
+	s.Lock()
+	defer s.Unlock()
+	return s.enc.Encode(r)
+}
+
+// NewFileAuditSink returns a JSON lines AuditSink that appends records to the
+// file, creating it with mode 0600 if it does not exist.
+func NewFileAuditSink(file string) (AuditSink, error) {
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return NewJSONAuditSink(f), nil
+}
+
+// audit sends a record for the RPC method call to ServerConfig.Audit, if set.
+// The command, status, and error are optional (nil).
+func (s *server) audit(ctx context.Context, method string, commandId string, rceCmd *cmd.Cmd, status *pb.Status, err error) {
+	if s.cfg.Audit == nil {
+		return
+	}
+	r := AuditRecord{
+		Time:      time.Now().UTC(),
+		Method:    method,
+		CommandId: commandId,
+		Code:      grpc.Code(err).String(),
+	}
+	r.Peer, r.Identity = peerIdentity(ctx)
+	if err != nil {
+		r.Error = grpc.ErrorDesc(err)
+	}
+	if rceCmd != nil {
+		r.CommandId = rceCmd.Id
+		r.Command = rceCmd.Name
+		r.Args = rceCmd.Args
+	}
+	if status != nil {
+		r.State = status.State.String()
+		if status.StopTime > 0 {
+			exitCode := status.ExitCode
+			r.ExitCode = &exitCode
+		}
+		r.Signal = status.Signal
+	}
+	if err := s.cfg.Audit.Audit(r); err != nil {
+		log.Printf("audit error: %s: %+v", err, r)
+	}
+}
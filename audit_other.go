@@ -0,0 +1,14 @@
+// Copyright 2017-2023 Block, Inc.
+
+//go:build windows || plan9
+
+package rce
+
+import (
+	"errors"
+)
+
+// NewSyslogAuditSink returns an error because syslog is not supported on this platform.
+func NewSyslogAuditSink(network, raddr, tag string) (AuditSink, error) {
+	return nil, errors.New("syslog audit not supported on this platform")
+}
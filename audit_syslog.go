@@ -0,0 +1,21 @@
+// Copyright 2017-2023 Block, Inc.
+
+//go:build !windows && !plan9
+
+package rce
+
+import (
+	"log/syslog"
+)
+
+// NewSyslogAuditSink returns a JSON lines AuditSink that sends each record as
+// one syslog message with facility AUTH and severity INFO. If network is empty,
+// it connects to the local syslog server (like a Unix socket /dev/log);
+// otherwise, network and raddr are like "udp" and "logs.local:514". See syslog.Dial.
+func NewSyslogAuditSink(network, raddr, tag string) (AuditSink, error) {
+	w, err := syslog.Dial(network, raddr, syslog.LOG_AUTH|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, err
+	}
+	return NewJSONAuditSink(w), nil
+}
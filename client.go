@@ -3,13 +3,15 @@
 // Package rce provides a gRPC-based Remote Code Execution client and server.
 // The server (or "agent") runs on a remote host and executes a whitelist of
 // shell commands specified in a config file. The client calls the server to
-// execute whitelist commands. Commands from different clients run concurrently;
-// there are no safeguards against conflicting or incompatible commands.
+// execute whitelist commands. Commands from different clients run concurrently.
+// ServerConfig.MaxConcurrent and Spec.MaxConcurrent limit how many run at once,
+// and Spec.Locks prevents conflicting commands from running at the same time.
 package rce
 
 import (
 	"crypto/tls"
 	"io"
+	"net"
 	"time"
 
 	"github.com/square/rce-agent/pb"
@@ -19,26 +21,37 @@ import (
 	"google.golang.org/grpc/keepalive"
 )
 
+// NewClient uses these package variables as client defaults. Use the client
+// options instead: they do not affect other clients.
 var (
 	// ConnectTimeout describes the total timeout for establishing a client
 	// connection to the rceagent server.
+	//
+	// Deprecated: use WithConnectTimeout.
 	ConnectTimeout = time.Duration(10) * time.Second
 
 	// ConnectBackoffMaxDelay configures the dialer to use the
 	// provided maximum delay when backing off after
 	// failed connection attempts.
+	//
+	// Deprecated: use WithConnectBackoffMaxDelay.
 	ConnectBackoffMaxDelay = time.Duration(2) * time.Second
 
 	// KeepaliveTime is the interval at which the client sends keepalive
 	// probes to the server.
+	//
+	// Deprecated: use WithKeepalive.
 	KeepaliveTime = time.Duration(30) * time.Second
 
 	// KeepaliveTimeout is the amount of time the client waits to receive
 	// a response from the server after a keepalive probe.
+	//
+	// Deprecated: use WithKeepalive.
 	KeepaliveTimeout = time.Duration(20) * time.Second
 )
 
-// A Client calls a remote agent (server) to execute commands.
+// A Client calls a remote agent (server) to execute commands. Errors from the
+// agent are *Error, which wraps errors like ErrUnknownCommand and ErrNotFound.
 type Client interface {
 	// Connect to a remote agent.
 	Open(host, port string) error
@@ -54,6 +67,10 @@ type Client interface {
 	// an error.
 	Start(cmdName string, args []string) (id string, err error)
 
+	// StartCommand is like Start but takes a complete command, which allows
+	// optional fields like Timeout to be set.
+	StartCommand(cmd *pb.Command) (id string, err error)
+
 	// Wait for a command on the remote agent. This call blocks until the command
 	// completes. It returns the final statue of the command or an error.
 	Wait(id string) (*pb.Status, error)
@@ -69,22 +86,172 @@ type Client interface {
 
 	// Return a list of all running command IDs.
 	Running() ([]string, error)
+
+	// Stream the output of a command. This call blocks until the command
+	// completes and all its output has been received, calling fn for each
+	// line of STDOUT and STDERR in the order received. It does not reap the
+	// command; Wait or Stop must still be called. ErrNotFound is returned if
+	// Wait or Stop has already been called.
+	Stream(id string, fn func(*pb.Output)) error
+
+	// Return a list of allowed commands on the remote agent that the client can run.
+	Commands() ([]*pb.CommandSpec, error)
+
+	// Describe an allowed command on the remote agent. ErrUnknownCommand is
+	// returned if the command is not allowed, and ErrPermissionDenied if the
+	// client cannot run it.
+	DescribeCommand(name string) (*pb.CommandSpec, error)
+
+	// Return done commands in the remote agent history that match the query,
+	// oldest first. A zero query matches all commands. ErrHistoryDisabled is
+	// returned if the agent has no history (ServerConfig.HistorySize and
+	// HistoryAge).
+	History(query *pb.HistoryQuery) ([]*pb.HistoryRecord, error)
+}
+
+// A ContextClient is a Client with methods that take a context to cancel the
+// call or set its deadline. The Context methods are like the Client methods but,
+// if the context has no deadline, the client default timeout is used (see
+// WithCallTimeout and WithWaitTimeout). The Client methods call these with
+// context.Background(). NewClient returns a ContextClient.
+type ContextClient interface {
+	Client
+
+	OpenContext(ctx context.Context, host, port string) error
+	StartContext(ctx context.Context, cmdName string, args []string) (id string, err error)
+	StartCommandContext(ctx context.Context, cmd *pb.Command) (id string, err error)
+	WaitContext(ctx context.Context, id string) (*pb.Status, error)
+	GetStatusContext(ctx context.Context, id string) (*pb.Status, error)
+	StopContext(ctx context.Context, id string) error
+	RunningContext(ctx context.Context) ([]string, error)
+	StreamContext(ctx context.Context, id string, fn func(*pb.Output)) error
+	CommandsContext(ctx context.Context) ([]*pb.CommandSpec, error)
+	DescribeCommandContext(ctx context.Context, name string) (*pb.CommandSpec, error)
+	HistoryContext(ctx context.Context, query *pb.HistoryQuery) ([]*pb.HistoryRecord, error)
 }
 
 type client struct {
-	host      string
-	port      string
-	conn      *grpc.ClientConn
-	agent     pb.RCEAgentClient
-	tlsConfig *tls.Config
+	host        string
+	port        string
+	conn        *grpc.ClientConn
+	agent       pb.RCEAgentClient
+	tlsConfig   *tls.Config
+	callTimeout time.Duration
+	waitTimeout time.Duration
+	// Dial options
+	connectTimeout  time.Duration
+	backoffMaxDelay time.Duration
+	keepalive       keepalive.ClientParameters
+	dialOpts        []grpc.DialOption
+}
+
+// A ClientOption configures a Client made by NewClient.
+type ClientOption func(*client)
+
+// WithCallTimeout sets the default timeout of calls other than Wait and Stream
+// when the context has no deadline. The default is 1 second. Zero means no
+// default timeout.
+func WithCallTimeout(d time.Duration) ClientOption {
+	return func(c *client) {
+		c.callTimeout = d
+	}
+}
+
+// WithWaitTimeout sets the default timeout of Wait and Stream calls when the
+// context has no deadline. The default is zero: no default timeout, so they
+// block until the command is done.
+func WithWaitTimeout(d time.Duration) ClientOption {
+	return func(c *client) {
+		c.waitTimeout = d
+	}
+}
+
+// WithConnectTimeout sets the total timeout for establishing a connection to
+// the agent in Open. The default is 10 seconds.
+func WithConnectTimeout(d time.Duration) ClientOption {
+	return func(c *client) {
+		c.connectTimeout = d
+	}
+}
+
+// WithConnectBackoffMaxDelay sets the maximum delay when backing off after
+// failed connection attempts. The default is 2 seconds.
+func WithConnectBackoffMaxDelay(d time.Duration) ClientOption {
+	return func(c *client) {
+		c.backoffMaxDelay = d
+	}
+}
+
+// WithKeepalive sets the interval at which the client sends keepalive probes
+// to the agent (default 30 seconds), and how long it waits for a response to a
+// probe (default 20 seconds).
+func WithKeepalive(interval, timeout time.Duration) ClientOption {
+	return func(c *client) {
+		c.keepalive = keepalive.ClientParameters{
+			Time:    interval,
+			Timeout: timeout,
+		}
+	}
+}
+
+// WithDialOptions adds gRPC dial options used by Open. They are applied after
+// the client options, so they take precedence.
+func WithDialOptions(opts ...grpc.DialOption) ClientOption {
+	return func(c *client) {
+		c.dialOpts = append(c.dialOpts, opts...)
+	}
+}
+
+// WithUnaryInterceptors adds gRPC client interceptors for unary calls, like
+// Start and Wait. They are called in order.
+func WithUnaryInterceptors(interceptors ...grpc.UnaryClientInterceptor) ClientOption {
+	return WithDialOptions(grpc.WithChainUnaryInterceptor(interceptors...))
+}
+
+// WithStreamInterceptors adds gRPC client interceptors for streaming calls,
+// like Stream and Running. They are called in order.
+func WithStreamInterceptors(interceptors ...grpc.StreamClientInterceptor) ClientOption {
+	return WithDialOptions(grpc.WithChainStreamInterceptor(interceptors...))
 }
 
-// NewClient makes a new Client.
-func NewClient(tlsConfig *tls.Config) Client {
-	return &client{tlsConfig: tlsConfig}
+// WithDialer sets a custom dialer to connect to the agent address "host:port",
+// for example to connect through a proxy.
+func WithDialer(dialer func(ctx context.Context, addr string) (net.Conn, error)) ClientOption {
+	return WithDialOptions(grpc.WithContextDialer(dialer))
+}
+
+// NewClient makes a new Client. It also implements ContextClient.
+func NewClient(tlsConfig *tls.Config, opts ...ClientOption) ContextClient {
+	c := &client{
+		tlsConfig:       tlsConfig,
+		callTimeout:     time.Second,
+		connectTimeout:  ConnectTimeout,
+		backoffMaxDelay: ConnectBackoffMaxDelay,
+		keepalive: keepalive.ClientParameters{
+			Time:    KeepaliveTime,
+			Timeout: KeepaliveTimeout,
+		},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// withTimeout returns ctx with the default timeout d if ctx has no deadline
+// and d is greater than zero.
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok || d <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d)
 }
 
 func (c *client) Open(host, port string) error {
+	return c.OpenContext(context.Background(), host, port)
+}
+
+func (c *client) OpenContext(ctx context.Context, host, port string) error {
 	var opt grpc.DialOption
 	if c.tlsConfig == nil {
 		opt = grpc.WithInsecure()
@@ -96,23 +263,21 @@ func (c *client) Open(host, port string) error {
 		}
 		opt = grpc.WithTransportCredentials(creds)
 	}
-	conn, err := grpc.Dial(
-		host+":"+port,
+	dialOpts := []grpc.DialOption{
 		opt, // insecure or with TLS
 
 		// Block = actually connect. Timeout = max time to retry on failure
 		// (no option to set retry count). Backoff delay = time between retries,
 		// up to Timeout.
 		grpc.WithBlock(),
-		grpc.WithTimeout(ConnectTimeout),
-		grpc.WithBackoffMaxDelay(ConnectBackoffMaxDelay),
-		grpc.WithKeepaliveParams(keepalive.ClientParameters{
-			Time:    KeepaliveTime,
-			Timeout: KeepaliveTimeout,
-		}),
-	)
+		grpc.WithTimeout(c.connectTimeout),
+		grpc.WithBackoffMaxDelay(c.backoffMaxDelay),
+		grpc.WithKeepaliveParams(c.keepalive),
+	}
+	dialOpts = append(dialOpts, c.dialOpts...)
+	conn, err := grpc.DialContext(ctx, host+":"+port, dialOpts...)
 	if err != nil {
-		return err
+		return unavailable(host+":"+port, err)
 	}
 	c.conn = conn
 	c.agent = pb.NewRCEAgentClient(conn)
@@ -133,46 +298,76 @@ func (c *client) AgentAddr() (string, string) {
 }
 
 func (c *client) Start(cmdName string, args []string) (string, error) {
-	cmd := &pb.Command{
+	return c.StartContext(context.Background(), cmdName, args)
+}
+
+func (c *client) StartContext(ctx context.Context, cmdName string, args []string) (string, error) {
+	return c.StartCommandContext(ctx, &pb.Command{
 		Name:      cmdName,
 		Arguments: args,
-	}
+	})
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+func (c *client) StartCommand(cmd *pb.Command) (string, error) {
+	return c.StartCommandContext(context.Background(), cmd)
+}
+
+func (c *client) StartCommandContext(ctx context.Context, cmd *pb.Command) (string, error) {
+	ctx, cancel := withTimeout(ctx, c.callTimeout)
 	defer cancel()
 
 	id, err := c.agent.Start(ctx, cmd)
 	if err != nil {
-		return "", err
+		return "", clientError(err)
 	}
 
 	return id.ID, nil
 }
 
 func (c *client) Wait(id string) (*pb.Status, error) {
-	return c.agent.Wait(context.TODO(), &pb.ID{ID: id})
+	return c.WaitContext(context.Background(), id)
+}
+
+func (c *client) WaitContext(ctx context.Context, id string) (*pb.Status, error) {
+	ctx, cancel := withTimeout(ctx, c.waitTimeout)
+	defer cancel()
+	status, err := c.agent.Wait(ctx, &pb.ID{ID: id})
+	return status, clientError(err)
 }
 
 func (c *client) GetStatus(id string) (*pb.Status, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	return c.GetStatusContext(context.Background(), id)
+}
+
+func (c *client) GetStatusContext(ctx context.Context, id string) (*pb.Status, error) {
+	ctx, cancel := withTimeout(ctx, c.callTimeout)
 	defer cancel()
-	return c.agent.GetStatus(ctx, &pb.ID{ID: id})
+	status, err := c.agent.GetStatus(ctx, &pb.ID{ID: id})
+	return status, clientError(err)
 }
 
 func (c *client) Stop(id string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	return c.StopContext(context.Background(), id)
+}
+
+func (c *client) StopContext(ctx context.Context, id string) error {
+	ctx, cancel := withTimeout(ctx, c.callTimeout)
 	defer cancel()
 	_, err := c.agent.Stop(ctx, &pb.ID{ID: id})
-	return err
+	return clientError(err)
 }
 
 func (c *client) Running() ([]string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	return c.RunningContext(context.Background())
+}
+
+func (c *client) RunningContext(ctx context.Context) ([]string, error) {
+	ctx, cancel := withTimeout(ctx, c.callTimeout)
 	defer cancel()
 
 	stream, err := c.agent.Running(ctx, &pb.Empty{})
 	if err != nil {
-		return nil, err
+		return nil, clientError(err)
 	}
 
 	ids := []string{}
@@ -182,10 +377,105 @@ func (c *client) Running() ([]string, error) {
 			break
 		}
 		if err != nil {
-			return nil, err
+			return nil, clientError(err)
 		}
 		ids = append(ids, id.ID)
 	}
 
 	return ids, nil
 }
+
+func (c *client) Commands() ([]*pb.CommandSpec, error) {
+	return c.CommandsContext(context.Background())
+}
+
+func (c *client) CommandsContext(ctx context.Context) ([]*pb.CommandSpec, error) {
+	ctx, cancel := withTimeout(ctx, c.callTimeout)
+	defer cancel()
+
+	stream, err := c.agent.ListCommands(ctx, &pb.Empty{})
+	if err != nil {
+		return nil, clientError(err)
+	}
+
+	specs := []*pb.CommandSpec{}
+	for {
+		spec, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, clientError(err)
+		}
+		specs = append(specs, spec)
+	}
+
+	return specs, nil
+}
+
+func (c *client) DescribeCommand(name string) (*pb.CommandSpec, error) {
+	return c.DescribeCommandContext(context.Background(), name)
+}
+
+func (c *client) DescribeCommandContext(ctx context.Context, name string) (*pb.CommandSpec, error) {
+	ctx, cancel := withTimeout(ctx, c.callTimeout)
+	defer cancel()
+	spec, err := c.agent.DescribeCommand(ctx, &pb.Command{Name: name})
+	return spec, clientError(err)
+}
+
+func (c *client) History(query *pb.HistoryQuery) ([]*pb.HistoryRecord, error) {
+	return c.HistoryContext(context.Background(), query)
+}
+
+func (c *client) HistoryContext(ctx context.Context, query *pb.HistoryQuery) ([]*pb.HistoryRecord, error) {
+	ctx, cancel := withTimeout(ctx, c.callTimeout)
+	defer cancel()
+
+	if query == nil {
+		query = &pb.HistoryQuery{}
+	}
+	stream, err := c.agent.History(ctx, query)
+	if err != nil {
+		return nil, clientError(err)
+	}
+
+	records := []*pb.HistoryRecord{}
+	for {
+		r, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, clientError(err)
+		}
+		records = append(records, r)
+	}
+
+	return records, nil
+}
+
+func (c *client) Stream(id string, fn func(*pb.Output)) error {
+	return c.StreamContext(context.Background(), id, fn)
+}
+
+func (c *client) StreamContext(ctx context.Context, id string, fn func(*pb.Output)) error {
+	ctx, cancel := withTimeout(ctx, c.waitTimeout)
+	defer cancel()
+
+	stream, err := c.agent.Stream(ctx, &pb.ID{ID: id})
+	if err != nil {
+		return clientError(err)
+	}
+
+	for {
+		out, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return clientError(err)
+		}
+		fn(out)
+	}
+}
@@ -3,12 +3,21 @@
 package rce_test
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/go-test/deep"
 	"github.com/square/rce-agent"
 	"github.com/square/rce-agent/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func TestClientExitZero(t *testing.T) {
@@ -100,4 +109,634 @@ func TestClientLongRunningCommand(t *testing.T) {
 	if finalStatus.ExitCode != -1 {
 		t.Errorf("got exit %d, expected -1", finalStatus.ExitCode)
 	}
+	if finalStatus.State != pb.STATE_STOPPED {
+		t.Errorf("Status.State = %s, expected STOPPED", finalStatus.State)
+	}
+	if finalStatus.Signal != "SIGTERM" {
+		t.Errorf("Status.Signal = %s, expected SIGTERM", finalStatus.Signal)
+	}
+}
+
+func TestClientStream(t *testing.T) {
+	s := rce.NewServer(LADDR, nil, whitelist)
+	go s.StartServer()
+	defer s.StopServer()
+
+	time.Sleep(200 * time.Millisecond)
+
+	c := rce.NewClient(nil)
+	err := c.Open(HOST, PORT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	id, err := c.Start("count", []string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gotStdout := []string{}
+	gotStderr := []string{}
+	err = c.Stream(id, func(out *pb.Output) {
+		if out.Stream == pb.STREAM_STDERR {
+			gotStderr = append(gotStderr, out.Line)
+		} else {
+			gotStdout = append(gotStdout, out.Line)
+		}
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if diff := deep.Equal(gotStdout, []string{"1", "2", "3"}); diff != nil {
+		t.Error(diff)
+	}
+	if diff := deep.Equal(gotStderr, []string{"err1", "err2", "err3"}); diff != nil {
+		t.Error(diff)
+	}
+
+	// Stream doesn't reap the command, and the final status has the same output
+	status, err := c.Wait(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(status.Stdout, gotStdout); diff != nil {
+		t.Error(diff)
+	}
+
+	// Once reaped, the command can't be streamed
+	err = c.Stream(id, func(out *pb.Output) {})
+	if err == nil {
+		t.Error("Stream after Wait returned nil error, expected not found")
+	}
+}
+
+func TestClientParameters(t *testing.T) {
+	s := rce.NewServer(LADDR, nil, whitelist)
+	go s.StartServer()
+	defer s.StopServer()
+
+	time.Sleep(200 * time.Millisecond)
+
+	c := rce.NewClient(nil)
+	err := c.Open(HOST, PORT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	id, err := c.StartCommand(&pb.Command{
+		Name:       "greet",
+		Parameters: map[string]string{"name": "world", "count": "2"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := c.Wait(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(status.Args, []string{"hello world", "x2"}); diff != nil {
+		t.Error(diff)
+	}
+	if diff := deep.Equal(status.Stdout, []string{"hello world x2"}); diff != nil {
+		t.Error(diff)
+	}
+
+	_, err = c.StartCommand(&pb.Command{
+		Name:       "greet",
+		Parameters: map[string]string{"name": "$(reboot)"},
+	})
+	if err == nil {
+		t.Error("got nil error for invalid parameter value, expected an error")
+	}
+}
+
+func TestClientCommands(t *testing.T) {
+	s := rce.NewServer(LADDR, nil, whitelist)
+	go s.StartServer()
+	defer s.StopServer()
+
+	time.Sleep(200 * time.Millisecond)
+
+	c := rce.NewClient(nil)
+	err := c.Open(HOST, PORT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	specs, err := c.Commands()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, spec := range specs {
+		names = append(names, spec.Name)
+	}
+	expectNames := []string{}
+	for _, spec := range whitelist {
+		expectNames = append(expectNames, spec.Name)
+	}
+	if diff := deep.Equal(names, expectNames); diff != nil {
+		t.Error(diff)
+	}
+
+	gotSpec, err := c.DescribeCommand("greet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectSpec := &pb.CommandSpec{
+		Name:        "greet",
+		Description: "Greet someone",
+		Usage:       "greet name=world|there [count=1-3]",
+		Owner:       "rce",
+		Params: []*pb.Parameter{
+			{Name: "name", Type: "enum", Required: true, Values: []string{"world", "there"}},
+			{Name: "count", Type: "int", Default: "1", Min: "1", Max: "3"},
+		},
+	}
+	if diff := deep.Equal(gotSpec, expectSpec); diff != nil {
+		t.Error(diff)
+	}
+
+	gotSpec, err = c.DescribeCommand("sleep60.timeout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotSpec.Timeout != 1 {
+		t.Errorf("got timeout %d, expected 1", gotSpec.Timeout)
+	}
+
+	_, err = c.DescribeCommand("nonexistent-cmd")
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("got error %v, expected codes.InvalidArgument", err)
+	}
+}
+
+func TestClientHistory(t *testing.T) {
+	s := rce.NewServerWithConfig(rce.ServerConfig{
+		Addr:            LADDR,
+		AllowedCommands: whitelist,
+		HistoryAge:      time.Minute,
+	})
+	go s.StartServer()
+	defer s.StopServer()
+
+	time.Sleep(200 * time.Millisecond)
+
+	c := rce.NewClient(nil)
+	err := c.Open(HOST, PORT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	id, err := c.Start("exit.zero", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotStatus, err := c.Wait(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond) // recorded after done
+
+	// Status is gone after Wait but still in history
+	if _, err := c.GetStatus(id); status.Code(err) != codes.NotFound {
+		t.Errorf("got error %v, expected codes.NotFound", err)
+	}
+	records, err := c.History(&pb.HistoryQuery{Name: "exit.zero"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("got %d records, expected 1", len(records))
+	}
+	if diff := deep.Equal(records[0].Status, gotStatus); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestClientHistoryDisabled(t *testing.T) {
+	s := rce.NewServer(LADDR, nil, whitelist)
+	go s.StartServer()
+	defer s.StopServer()
+
+	time.Sleep(200 * time.Millisecond)
+
+	c := rce.NewClient(nil)
+	err := c.Open(HOST, PORT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	_, err = c.History(nil)
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("got error %v, expected codes.FailedPrecondition", err)
+	}
+}
+
+func TestClientContext(t *testing.T) {
+	s := rce.NewServer(LADDR, nil, whitelist)
+	go s.StartServer()
+	defer s.StopServer()
+
+	time.Sleep(200 * time.Millisecond)
+
+	c := rce.NewClient(nil, rce.WithWaitTimeout(200*time.Millisecond))
+	err := c.OpenContext(context.Background(), HOST, PORT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	id, err := c.StartContext(context.Background(), "sleep60", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop(id)
+
+	// Default wait timeout
+	_, err = c.Wait(id)
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("got error %v, expected codes.DeadlineExceeded", err)
+	}
+
+	// Context deadline overrides default wait timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	t0 := time.Now()
+	_, err = c.WaitContext(ctx, id)
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("got error %v, expected codes.DeadlineExceeded", err)
+	}
+	if d := time.Since(t0); d < 400*time.Millisecond {
+		t.Errorf("WaitContext returned after %s, expected 500ms", d)
+	}
+
+	// Canceled context
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+	}()
+	err = c.StreamContext(ctx, id, func(*pb.Output) {})
+	if status.Code(err) != codes.Canceled {
+		t.Errorf("got error %v, expected codes.Canceled", err)
+	}
+
+	gotStatus, err := c.GetStatusContext(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotStatus.State != pb.STATE_RUNNING {
+		t.Errorf("got state %s, expected RUNNING", gotStatus.State)
+	}
+}
+
+func TestClientOptions(t *testing.T) {
+	var mux sync.Mutex
+	var serverCalls, clientCalls, dials []string
+	s := rce.NewServerWithConfig(rce.ServerConfig{
+		Addr:            LADDR,
+		AllowedCommands: whitelist,
+		ServerOptions:   []grpc.ServerOption{grpc.MaxRecvMsgSize(1024)},
+		UnaryInterceptors: []grpc.UnaryServerInterceptor{
+			func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+				mux.Lock()
+				serverCalls = append(serverCalls, info.FullMethod)
+				mux.Unlock()
+				return handler(ctx, req)
+			},
+		},
+	})
+	go s.StartServer()
+	defer s.StopServer()
+
+	time.Sleep(200 * time.Millisecond)
+
+	c := rce.NewClient(nil,
+		rce.WithDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			mux.Lock()
+			dials = append(dials, addr)
+			mux.Unlock()
+			return (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+		}),
+		rce.WithUnaryInterceptors(func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			mux.Lock()
+			clientCalls = append(clientCalls, method)
+			mux.Unlock()
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}),
+	)
+	err := c.Open(HOST, PORT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	id, err := c.Start("exit.zero", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Wait(id); err != nil {
+		t.Fatal(err)
+	}
+
+	// Server MaxRecvMsgSize
+	_, err = c.Start("echo", []string{strings.Repeat("x", 2048)})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("got error %v, expected codes.ResourceExhausted", err)
+	}
+
+	mux.Lock()
+	defer mux.Unlock()
+	if diff := deep.Equal(dials, []string{LADDR}); diff != nil {
+		t.Error(diff)
+	}
+	expectCalls := []string{"/rce.RCEAgent/Start", "/rce.RCEAgent/Wait"}
+	if diff := deep.Equal(serverCalls, expectCalls); diff != nil {
+		t.Error(diff)
+	}
+	expectCalls = append(expectCalls, "/rce.RCEAgent/Start")
+	if diff := deep.Equal(clientCalls, expectCalls); diff != nil {
+		t.Error(diff)
+	}
+
+	// Connect timeout
+	c2 := rce.NewClient(nil, rce.WithConnectTimeout(200*time.Millisecond))
+	t0 := time.Now()
+	if err := c2.Open(HOST, "5509"); err == nil {
+		c2.Close()
+		t.Fatal("Open did not return an error")
+	}
+	if d := time.Since(t0); d > 2*time.Second {
+		t.Errorf("Open returned after %s, expected 200ms", d)
+	}
+}
+
+func TestRemoteCmd(t *testing.T) {
+	s := rce.NewServer(LADDR, nil, whitelist)
+	go s.StartServer()
+	defer s.StopServer()
+
+	time.Sleep(200 * time.Millisecond)
+
+	c := rce.NewClient(nil)
+	err := c.Open(HOST, PORT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// Output
+	rc := rce.Command(c, "echo", "hello")
+	out, err := rc.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "hello\n" {
+		t.Errorf("got output %q, expected %q", out, "hello\n")
+	}
+	if rc.Status == nil || rc.Status.State != pb.STATE_COMPLETE || rc.Status.ID != rc.ID {
+		t.Errorf("got status %+v, expected COMPLETE for ID %s", rc.Status, rc.ID)
+	}
+	if err := rc.Run(); err != rce.ErrAlreadyStarted {
+		t.Errorf("got error %v, expected ErrAlreadyStarted", err)
+	}
+
+	// Run with separate Stdout and Stderr
+	var stdout, stderr bytes.Buffer
+	rc = rce.Command(c, "count")
+	rc.Stdout = &stdout
+	rc.Stderr = &stderr
+	if err := rc.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != "1\n2\n3\n" {
+		t.Errorf("got stdout %q", stdout.String())
+	}
+	if stderr.String() != "err1\nerr2\nerr3\n" {
+		t.Errorf("got stderr %q", stderr.String())
+	}
+
+	// CombinedOutput and ExitError
+	rc = rce.Command(c, "exit.one")
+	out, err = rc.CombinedOutput()
+	var exitErr *rce.ExitError
+	if !errors.As(err, &exitErr) {
+		t.Fatalf("got error %v, expected *rce.ExitError", err)
+	}
+	if exitErr.ExitCode() != 1 || exitErr.State != pb.STATE_FAIL {
+		t.Errorf("got exit code %d, state %s; expected 1, FAIL", exitErr.ExitCode(), exitErr.State)
+	}
+	if len(out) != len("out\nerr\n") || !strings.Contains(string(out), "out\n") || !strings.Contains(string(out), "err\n") {
+		t.Errorf("got combined output %q", out)
+	}
+	if _, err := rce.Command(c, "echo").CombinedOutput(); err != nil {
+		t.Error(err)
+	}
+
+	// Context done: command is stopped and reaped
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	rc = rce.CommandContext(ctx, c, "sleep60")
+	if err := rc.Run(); err != context.DeadlineExceeded {
+		t.Errorf("got error %v, expected context.DeadlineExceeded", err)
+	}
+	if rc.Status == nil || rc.Status.State != pb.STATE_STOPPED {
+		t.Errorf("got status %+v, expected STOPPED", rc.Status)
+	}
+	if _, err := c.GetStatus(rc.ID); status.Code(err) != codes.NotFound {
+		t.Errorf("got error %v, expected codes.NotFound", err)
+	}
+
+	// Context done but command ignores the stop: Wait returns after WaitDelay
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	rc = rce.CommandContext(ctx, c, "sleep.noterm")
+	rc.WaitDelay = 300 * time.Millisecond
+	t0 := time.Now()
+	if err := rc.Run(); err != context.DeadlineExceeded {
+		t.Errorf("got error %v, expected context.DeadlineExceeded", err)
+	}
+	if d := time.Since(t0); d > 2*time.Second {
+		t.Errorf("Run returned after %s, expected about 500ms", d)
+	}
+	if rc.Status != nil {
+		t.Errorf("got status %+v, expected nil (not reaped)", rc.Status)
+	}
+
+	// Misuse
+	rc = rce.Command(c, "echo")
+	if err := rc.Wait(); err != rce.ErrNotStarted {
+		t.Errorf("got error %v, expected ErrNotStarted", err)
+	}
+	rc.Stdout = &stdout
+	if _, err := rc.Output(); err != rce.ErrStdoutSet {
+		t.Errorf("got error %v, expected ErrStdoutSet", err)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	s := rce.NewServer(LADDR, nil, whitelist)
+	go s.StartServer()
+	defer s.StopServer()
+
+	time.Sleep(200 * time.Millisecond)
+
+	c := rce.NewClient(nil)
+	err := c.Open(HOST, PORT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	_, err = c.Start("nonexistent-cmd", nil)
+	if !errors.Is(err, rce.ErrUnknownCommand) {
+		t.Errorf("got error %v, expected ErrUnknownCommand", err)
+	}
+	_, err = c.DescribeCommand("nonexistent-cmd")
+	if !errors.Is(err, rce.ErrUnknownCommand) {
+		t.Errorf("got error %v, expected ErrUnknownCommand", err)
+	}
+
+	_, err = c.StartCommand(&pb.Command{Name: "greet", Parameters: map[string]string{"name": "nobody"}})
+	var rceErr *rce.Error
+	if !errors.As(err, &rceErr) {
+		t.Fatalf("got error %v, expected *rce.Error", err)
+	}
+	if rceErr.Err != rce.ErrInvalidArgs || rceErr.Code != codes.InvalidArgument || rceErr.Reason != "INVALID_ARGUMENTS" {
+		t.Errorf("got error %+v, expected ErrInvalidArgs", rceErr)
+	}
+	if diff := deep.Equal(rceErr.Metadata, map[string]string{"command": "greet"}); diff != nil {
+		t.Error(diff)
+	}
+	if _, ok := rceErr.Violations["Parameters.name"]; !ok || len(rceErr.Violations) != 1 {
+		t.Errorf("got violations %v, expected Parameters.name", rceErr.Violations)
+	}
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("got code %s, expected InvalidArgument", status.Code(err))
+	}
+
+	_, err = c.Start("echo.noargs", []string{"x"})
+	if !errors.As(err, &rceErr) || rceErr.Err != rce.ErrInvalidArgs {
+		t.Fatalf("got error %v, expected ErrInvalidArgs", err)
+	}
+	if _, ok := rceErr.Violations["Arguments"]; !ok {
+		t.Errorf("got violations %v, expected Arguments", rceErr.Violations)
+	}
+
+	_, err = c.GetStatus("nonexistent-id")
+	if !errors.Is(err, rce.ErrNotFound) {
+		t.Errorf("got error %v, expected ErrNotFound", err)
+	}
+	if !errors.As(err, &rceErr) || rceErr.Metadata["id"] != "nonexistent-id" {
+		t.Errorf("got error %+v, expected metadata id", rceErr)
+	}
+
+	_, err = c.History(nil)
+	if !errors.Is(err, rce.ErrHistoryDisabled) {
+		t.Errorf("got error %v, expected ErrHistoryDisabled", err)
+	}
+
+	c2 := rce.NewClient(nil, rce.WithConnectTimeout(200*time.Millisecond))
+	err = c2.Open(HOST, "5509")
+	if !errors.Is(err, rce.ErrAgentUnavailable) {
+		t.Errorf("got error %v, expected ErrAgentUnavailable", err)
+	}
+}
+
+func TestFleet(t *testing.T) {
+	s := rce.NewServer(LADDR, nil, whitelist)
+	go s.StartServer()
+	defer s.StopServer()
+
+	time.Sleep(200 * time.Millisecond)
+
+	bad := HOST + ":5509" // no agent
+	opts := []rce.ClientOption{rce.WithConnectTimeout(200 * time.Millisecond)}
+	skipped := func(results []rce.FleetResult) []bool {
+		s := []bool{}
+		for _, r := range results {
+			s = append(s, r.Skipped)
+		}
+		return s
+	}
+
+	// All agents complete
+	f := rce.Fleet{
+		Agents:        []string{LADDR, LADDR, LADDR, LADDR, LADDR},
+		ClientOptions: opts,
+		Parallel:      2,
+		BatchSize:     2,
+	}
+	results, summary, err := f.Run(context.Background(), &pb.Command{Name: "echo", Arguments: []string{"hi"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Agents != 5 || summary.Complete != 5 {
+		t.Errorf("got summary %s, expected 5 complete", summary)
+	}
+	for _, r := range results {
+		if r.Agent != LADDR || r.Err != nil || r.Status == nil || r.Status.State != pb.STATE_COMPLETE {
+			t.Errorf("got result %+v, expected COMPLETE", r)
+		}
+	}
+
+	// Canary fails: rollout stops
+	f = rce.Fleet{
+		Agents:        []string{bad, LADDR, LADDR},
+		ClientOptions: opts,
+		Canary:        1,
+	}
+	results, summary, err = f.Run(context.Background(), &pb.Command{Name: "exit.zero"})
+	if err != rce.ErrRolloutStopped {
+		t.Errorf("got error %v, expected ErrRolloutStopped", err)
+	}
+	if !errors.Is(results[0].Err, rce.ErrAgentUnavailable) || !results[0].Failed() {
+		t.Errorf("got canary result %+v, expected ErrAgentUnavailable", results[0])
+	}
+	if diff := deep.Equal(skipped(results), []bool{false, true, true}); diff != nil {
+		t.Error(diff)
+	}
+	if summary.Failed != 1 || summary.Skipped != 2 {
+		t.Errorf("got summary %s, expected 1 failed, 2 skipped", summary)
+	}
+
+	// Failure threshold: 2 of 5 agents (40%) stops the rollout
+	f = rce.Fleet{
+		Agents:           []string{LADDR, bad, bad, LADDR, LADDR},
+		ClientOptions:    opts,
+		Parallel:         1,
+		FailureThreshold: 40,
+	}
+	results, _, err = f.Run(context.Background(), &pb.Command{Name: "exit.zero"})
+	if err != rce.ErrRolloutStopped {
+		t.Errorf("got error %v, expected ErrRolloutStopped", err)
+	}
+	if diff := deep.Equal(skipped(results), []bool{false, false, false, true, true}); diff != nil {
+		t.Error(diff)
+	}
+
+	// Per-agent timeout: command is stopped
+	f = rce.Fleet{
+		Agents:        []string{LADDR, LADDR},
+		ClientOptions: opts,
+		Timeout:       300 * time.Millisecond,
+	}
+	results, summary, err = f.Run(context.Background(), &pb.Command{Name: "sleep60"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Failed != 2 {
+		t.Errorf("got summary %s, expected 2 failed", summary)
+	}
+	for _, r := range results {
+		if r.Err != context.DeadlineExceeded || r.Status == nil || r.Status.State != pb.STATE_STOPPED {
+			t.Errorf("got result %+v, expected DeadlineExceeded and STOPPED", r)
+		}
+	}
 }
@@ -0,0 +1,147 @@
+// Copyright 2017-2023 Block, Inc.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+var (
+	ErrInvalidArgPolicy = errors.New("invalid args policy")
+	ErrArgsNotAllowed   = errors.New("command does not allow arguments")
+	ErrTooManyArgs      = errors.New("too many arguments")
+	ErrArgNotAllowed    = errors.New("argument not allowed")
+)
+
+// ArgPolicy restricts the arguments that clients can append to a command.
+// In YAML, it is either the string "none" (clients cannot pass any arguments)
+// or a map:
+//
+//	args:
+//	  max: 2
+//	  allow: [-l, -a, /tmp]
+//	  match: ["-[la]+", "/tmp/[a-z]+"]
+//
+// All fields are optional and all set fields must be satisfied: at most max
+// arguments, each argument must be one of allow, and argument N must match
+// regular expression match[N] (the whole argument must match). If match is
+// set, there can be no more arguments than match expressions.
+type ArgPolicy struct {
+	None  bool     `yaml:"-"`
+	Max   int      `yaml:"max"`
+	Allow []string `yaml:"allow"`
+	Match []string `yaml:"match"`
+
+	match []*regexp.Regexp // compiled Match
+}
+
+// ArgError describes a client argument that is not allowed by an ArgPolicy.
+type ArgError struct {
+	Index int    // position of argument, starting at zero
+	Arg   string // the argument
+	Err   error  // ErrArgNotAllowed or ErrTooManyArgs
+}
+
+func (e *ArgError) Error() string {
+	return fmt.Sprintf("%s: argument %d: %q", e.Err, e.Index+1, e.Arg)
+}
+
+func (e *ArgError) Unwrap() error {
+	return e.Err
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (p *ArgPolicy) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err == nil {
+		if s != "none" {
+			return ErrInvalidArgPolicy
+		}
+		*p = ArgPolicy{None: true}
+		return nil
+	}
+	type plain ArgPolicy
+	var v plain
+	if err := unmarshal(&v); err != nil {
+		return err
+	}
+	*p = ArgPolicy(v)
+	return nil
+}
+
+// regexps returns the compiled Match regular expressions. They are compiled
+// once by ValidateArgPolicy, else every call.
+func (p *ArgPolicy) regexps() ([]*regexp.Regexp, error) {
+	if len(p.match) == len(p.Match) {
+		return p.match, nil
+	}
+	match := make([]*regexp.Regexp, len(p.Match))
+	for i, expr := range p.Match {
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return nil, ErrInvalidArgPolicy
+		}
+		match[i] = re
+	}
+	return match, nil
+}
+
+// ValidateArgPolicy returns ErrInvalidArgPolicy if the Spec's args policy is
+// invalid, like a negative max or a match expression that does not compile.
+func (c Spec) ValidateArgPolicy() error {
+	if c.ArgPolicy == nil {
+		return nil
+	}
+	if c.ArgPolicy.Max < 0 {
+		return ErrInvalidArgPolicy
+	}
+	match, err := c.ArgPolicy.regexps()
+	if err != nil {
+		return err
+	}
+	c.ArgPolicy.match = match
+	return nil
+}
+
+// ValidateArgs returns an error if the Spec's args policy does not allow
+// the client arguments. The error is ErrArgsNotAllowed if the policy is
+// "none", else an *ArgError for the first argument not allowed. If the Spec
+// has no args policy, all arguments are allowed.
+func (c Spec) ValidateArgs(args []string) error {
+	p := c.ArgPolicy
+	if p == nil || len(args) == 0 {
+		return nil
+	}
+	if p.None {
+		return ErrArgsNotAllowed
+	}
+	match, err := p.regexps()
+	if err != nil {
+		return err
+	}
+
+	for i, arg := range args {
+		if (p.Max > 0 && i >= p.Max) || (len(match) > 0 && i >= len(match)) {
+			return &ArgError{Index: i, Arg: arg, Err: ErrTooManyArgs}
+		}
+		if len(p.Allow) > 0 && !contains(p.Allow, arg) {
+			return &ArgError{Index: i, Arg: arg, Err: ErrArgNotAllowed}
+		}
+		if len(match) > 0 && !match[i].MatchString(arg) {
+			return &ArgError{Index: i, Arg: arg, Err: ErrArgNotAllowed}
+		}
+	}
+
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
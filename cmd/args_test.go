@@ -0,0 +1,91 @@
+// Copyright 2017-2023 Block, Inc.
+
+package cmd_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/square/rce-agent/cmd"
+)
+
+func TestLoadCommandsArgs(t *testing.T) {
+	got, err := cmd.LoadCommands("../test/args-cmds.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := cmd.Runnable{
+		cmd.Spec{
+			Name:      "none",
+			Exec:      []string{"/bin/echo"},
+			ArgPolicy: &cmd.ArgPolicy{None: true},
+		},
+		cmd.Spec{
+			Name: "ls",
+			Exec: []string{"/bin/ls"},
+			ArgPolicy: &cmd.ArgPolicy{
+				Max:   2,
+				Allow: []string{"-l", "-a", "/tmp"},
+			},
+		},
+		cmd.Spec{
+			Name: "match",
+			Exec: []string{"/bin/ls"},
+			ArgPolicy: &cmd.ArgPolicy{
+				Match: []string{"-[la]+", "/tmp/[a-z]+"},
+			},
+		},
+	}
+	if diff := deep.Equal(got, expect); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestValidateArgs(t *testing.T) {
+	r, err := cmd.LoadCommands("../test/args-cmds.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		args  []string
+		err   error
+		index int
+	}{
+		{"none", nil, nil, 0},
+		{"none", []string{"hello"}, cmd.ErrArgsNotAllowed, 0},
+		{"ls", []string{"-l", "/tmp"}, nil, 0},
+		{"ls", []string{"-l", "/etc"}, cmd.ErrArgNotAllowed, 1},
+		{"ls", []string{"-l", "-a", "/tmp"}, cmd.ErrTooManyArgs, 2},
+		{"match", []string{"-la", "/tmp/foo"}, nil, 0},
+		{"match", []string{"-la", "/tmp/foo/../../etc"}, cmd.ErrArgNotAllowed, 1},
+		{"match", []string{"-x"}, cmd.ErrArgNotAllowed, 0},
+		{"match", []string{"-l", "/tmp/a", "/tmp/b"}, cmd.ErrTooManyArgs, 2},
+	}
+	for _, tt := range tests {
+		spec, _ := r.FindByName(tt.name)
+		err := spec.ValidateArgs(tt.args)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s %v: got error %v, expected %v", tt.name, tt.args, err, tt.err)
+			continue
+		}
+		var argErr *cmd.ArgError
+		if errors.As(err, &argErr) && argErr.Index != tt.index {
+			t.Errorf("%s %v: got error index %d, expected %d", tt.name, tt.args, argErr.Index, tt.index)
+		}
+	}
+
+	// No policy allows any args
+	spec := cmd.Spec{Name: "any", Exec: []string{"/bin/echo"}}
+	if err := spec.ValidateArgs([]string{"a", "b", "c"}); err != nil {
+		t.Errorf("got error %s, expected nil", err)
+	}
+
+	// Bad regex
+	spec.ArgPolicy = &cmd.ArgPolicy{Match: []string{"("}}
+	if err := spec.ValidateArgPolicy(); err != cmd.ErrInvalidArgPolicy {
+		t.Errorf("got error %v, expected ErrInvalidArgPolicy", err)
+	}
+}
@@ -6,8 +6,13 @@ package cmd
 import (
 	"errors"
 	"io/ioutil"
+	"os/exec"
 	"path/filepath"
+	"reflect"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	gocmd "github.com/go-cmd/cmd"
 	"github.com/gofrs/uuid"
@@ -20,25 +25,289 @@ var (
 	ErrDuplicateCommand = errors.New("duplicate command in repo")
 	ErrRelativePath     = errors.New("command uses relative path")
 	ErrNoCommands       = errors.New("no commands parsed")
+	ErrNegativeTimeout  = errors.New("command timeout is negative")
+	ErrShortTimeout     = errors.New("command timeout is less than 1s (use a duration like \"30s\")")
+	ErrNegativeMax      = errors.New("command max_concurrent is negative")
+	ErrInvalidLock      = errors.New("command lock name is empty or duplicated")
 )
 
-// Cmd represents a running command.
+// Cmd represents a running command. Call Start, not Cmd.Start, to start the
+// command so that its output is streamed and recorded.
 type Cmd struct {
 	Id   string
 	Name string
 	Cmd  *gocmd.Cmd
 	Args []string
+
+	// Timeout is the maximum run time of the command, if greater than zero.
+	// If the command runs longer, it is stopped and TimedOut returns true.
+	// It is set by NewCmd from Spec.Timeout and can be changed before Start.
+	Timeout time.Duration
+
+	// StartedBy is the identity of the client that started the command, if
+	// known. The rce.Server sets it from the client TLS certificate.
+	StartedBy string
+	// --
+	output   *output       // STDOUT and STDERR lines, in order received
+	doneChan chan struct{} // closed when command done and all output received
+	spec     Spec          // from NewCmd
+	mux      sync.Mutex    // guards fields below
+	started  bool          // Start called
+	timedOut bool          // stopped because Timeout exceeded
+	stopped  bool          // stopped by calling Stop
+	stopTs   int64         // when stopped, if the process never ran
+	execCmd  *exec.Cmd     // underlying command, set by go-cmd before exec
+	observed time.Time     // last observed by a client
+	watchers int           // clients observing now
 }
 
 // NewCmd makes a new Cmd with the given Spec and args, and assigns it an ID.
 func NewCmd(s Spec, args []string) *Cmd {
-	cmd := gocmd.NewCmd(s.Path(), args...)
-	return &Cmd{
+	c := &Cmd{
 		Id:   id(),
 		Name: s.Name,
-		Cmd:  cmd,
 		Args: args,
+
+		Timeout: s.Timeout,
+		// --
+		spec:     s,
+		output:   newOutput(),
+		doneChan: make(chan struct{}),
+		observed: time.Now(),
+	}
+	opts := gocmd.Options{
+		Streaming:  true,
+		BeforeExec: []func(*exec.Cmd){c.beforeExec},
+	}
+	c.Cmd = gocmd.NewCmdOptions(opts, s.Path(), args...)
+	c.Cmd.Env = s.Environ()
+	c.Cmd.Dir = s.Dir
+	return c
+}
+
+// Start starts the command, non-blocking. Output is received in the background
+// and available by calling Status or Output.
+func (c *Cmd) Start() {
+	c.mux.Lock()
+	if c.started || c.stopped {
+		c.mux.Unlock()
+		return // already started, or stopped before started
+	}
+	c.started = true
+	c.Cmd.Start()
+	c.mux.Unlock()
+
+	go c.stream()
+	if c.Timeout > 0 {
+		go c.timeout()
+	}
+}
+
+// Stop stops the command by sending its process group a SIGTERM. It is safe to
+// call multiple times. If the command is still running, Stopped returns true.
+// If the command has not been started, it is done and will not start.
+func (c *Cmd) Stop() error {
+	select {
+	case <-c.doneChan:
+		return nil // already done, nothing to stop
+	default:
+	}
+	c.mux.Lock()
+	if c.stopped {
+		c.mux.Unlock()
+		return nil
+	}
+	c.stopped = true
+	if !c.started {
+		c.stopTs = time.Now().UnixNano()
+		c.mux.Unlock()
+		c.output.close()
+		close(c.doneChan)
+		return nil
+	}
+	c.mux.Unlock()
+	return c.Cmd.Stop()
+}
+
+// Stopped returns true if Stop was called while the command was running or
+// before it was started.
+func (c *Cmd) Stopped() bool {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.stopped
+}
+
+// Signal returns the signal that terminated the command, or zero if the
+// command is running or was not terminated by a signal.
+func (c *Cmd) Signal() syscall.Signal {
+	select {
+	case <-c.doneChan:
+	default:
+		return 0 // still running
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.execCmd == nil || c.execCmd.ProcessState == nil {
+		return 0 // never started
 	}
+	ws, ok := c.execCmd.ProcessState.Sys().(syscall.WaitStatus)
+	if !ok || !ws.Signaled() {
+		return 0
+	}
+	return ws.Signal()
+}
+
+// TimedOut returns true if the command was stopped because it ran longer than
+// its Timeout.
+func (c *Cmd) TimedOut() bool {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.timedOut
+}
+
+// Done returns a channel that is closed when the command has finished and all
+// its output has been received.
+func (c *Cmd) Done() <-chan struct{} {
+	return c.doneChan
+}
+
+// Observe records that a client observed the command now, like getting its status.
+func (c *Cmd) Observe() {
+	c.mux.Lock()
+	c.observed = time.Now()
+	c.mux.Unlock()
+}
+
+// Watch records that a client is observing the command until the returned
+// function is called, like waiting for it or streaming its output.
+func (c *Cmd) Watch() func() {
+	c.mux.Lock()
+	c.watchers++
+	c.mux.Unlock()
+	return func() {
+		c.mux.Lock()
+		c.watchers--
+		c.observed = time.Now()
+		c.mux.Unlock()
+	}
+}
+
+// Unobserved returns how long since a client last observed the command, or
+// zero if a client is observing it now. A new command is observed when made.
+func (c *Cmd) Unobserved() time.Duration {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.watchers > 0 {
+		return 0
+	}
+	return time.Since(c.observed)
+}
+
+// Status returns the go-cmd status of the command with its full STDOUT and STDERR.
+// If the command was stopped before its process ran, StopTs is the time it was
+// stopped and Exit is -1.
+func (c *Cmd) Status() gocmd.Status {
+	status := c.Cmd.Status()
+	status.Stdout, status.Stderr = c.output.split()
+	c.mux.Lock()
+	if status.StopTs == 0 && c.stopTs > 0 {
+		status.StopTs = c.stopTs
+		status.Exit = -1
+	}
+	c.mux.Unlock()
+	return status
+}
+
+// Output returns the output lines starting at index i (zero for all output),
+// a channel that is closed when more output is available, and true if the
+// command is done and there is no more output. To follow output as it is
+// produced, increment i by the number of lines returned and call again after
+// the channel is closed.
+func (c *Cmd) Output(i int) ([]Line, <-chan struct{}, bool) {
+	return c.output.since(i)
+}
+
+// beforeExec is called by go-cmd immediately before the command is started,
+// in the same goroutine that starts it. An error is returned by exec.Cmd.Start
+// by setting exec.Cmd.Err, which makes the command fail to start.
+func (c *Cmd) beforeExec(cmd *exec.Cmd) {
+	c.mux.Lock()
+	c.execCmd = cmd
+	c.mux.Unlock()
+
+	if c.spec.cred == nil {
+		if err := c.spec.ResolveCredential(); err != nil {
+			cmd.Err = err
+			return
+		}
+	}
+	if c.spec.cred != nil {
+		if err := setCredential(cmd, c.spec.cred); err != nil {
+			cmd.Err = err
+			return
+		}
+	}
+
+	if c.spec.hasProcAttr() {
+		if err := setProcAttr(c.spec); err != nil {
+			cmd.Err = err
+			return
+		}
+	}
+}
+
+// stream receives lines from the go-cmd streaming channels until the command
+// is done and the channels are closed.
+func (c *Cmd) stream() {
+	stdout := c.Cmd.Stdout
+	stderr := c.Cmd.Stderr
+	for stdout != nil || stderr != nil {
+		select {
+		case line, ok := <-stdout:
+			if !ok {
+				stdout = nil
+				continue
+			}
+			c.output.add(Line{Text: line})
+		case line, ok := <-stderr:
+			if !ok {
+				stderr = nil
+				continue
+			}
+			c.output.add(Line{Stderr: true, Text: line})
+		}
+	}
+	<-c.Cmd.Done()
+	if c.Cmd.Status().StopTs == 0 {
+		// Stopped while starting, before the process ran
+		c.mux.Lock()
+		c.stopTs = time.Now().UnixNano()
+		c.mux.Unlock()
+	}
+	c.output.close()
+	close(c.doneChan)
+}
+
+// timeout stops the command if it's still running after Timeout.
+func (c *Cmd) timeout() {
+	timer := time.NewTimer(c.Timeout)
+	defer timer.Stop()
+	select {
+	case <-c.doneChan:
+		return
+	case <-c.Cmd.Done():
+		return
+	case <-timer.C:
+	}
+	c.mux.Lock()
+	if c.Cmd.Status().StopTs != 0 {
+		// Exited on its own just as the timer fired
+		c.mux.Unlock()
+		return
+	}
+	c.timedOut = true
+	c.mux.Unlock()
+	c.Cmd.Stop()
 }
 
 func id() string {
@@ -58,6 +327,114 @@ type Spec struct {
 
 	// Exec args, first being the absolute cmd path. Example: ["/usr/bin/lxc-ls", "--active"].
 	Exec []string `yaml:"exec"`
+
+	// Optional maximum run time of the command. Example: "30s". If the command
+	// runs longer, it is stopped. A client can request a shorter timeout but not
+	// a longer one. By default, there is no timeout. It must be at least 1s;
+	// a bare number like 30 is nanoseconds, so it is rejected.
+	Timeout time.Duration `yaml:"timeout"`
+
+	// Optional environment variables set for the command. Example: {"LANG": "C"}.
+	// These are set after and override inherited variables.
+	Env map[string]string `yaml:"env"`
+
+	// Optional agent environment variables that the command inherits. By default,
+	// the command inherits the agent's entire environment unless the commands
+	// file sets clean_env: true. See InheritEnv.
+	InheritEnv *InheritEnv `yaml:"inherit_env"`
+
+	// Optional working directory of the command, an absolute path. By default,
+	// the command runs in the agent's working directory.
+	Dir string `yaml:"dir"`
+
+	// Optional file mode creation mask of the command. Example: 0027.
+	// By default, the command inherits the agent's umask. Linux only.
+	Umask *int `yaml:"umask"`
+
+	// Optional nice level of the command, -20 (highest priority) to 19 (lowest).
+	// By default, the command inherits the agent's nice level. Linux only.
+	Nice int `yaml:"nice"`
+
+	// Optional I/O scheduling class and level of the command, like ionice(1).
+	// The class is "realtime", "best-effort", or "idle". Level is 0 (highest
+	// priority) to 7 (lowest) for realtime and best-effort. By default, the
+	// command inherits the agent's I/O priority. Linux only.
+	IONiceClass string `yaml:"ionice_class"`
+	IONiceLevel int    `yaml:"ionice_level"`
+
+	// Optional user and group (names or numeric IDs) to run the command as.
+	// The agent must have privileges to change to them (usually root). If only
+	// User is set, Group is the user's primary group. If only Group is set, the
+	// command runs as the agent user. Unix only.
+	User  string `yaml:"user"`
+	Group string `yaml:"group"`
+
+	// Optional supplementary groups (names or numeric IDs) of the command.
+	// By default, they are the groups of User, or none if User is not set.
+	Groups []string `yaml:"groups"`
+
+	// Optional policy for arguments that clients can append to Exec. By default,
+	// clients can append any arguments. See ArgPolicy.
+	ArgPolicy *ArgPolicy `yaml:"args"`
+
+	// Optional named, typed parameters that clients pass by name. Their values
+	// replace "{{name}}" placeholders in Exec. See Param.
+	Params []Param `yaml:"params"`
+
+	// Optional maximum number of instances of the command running at once, if
+	// greater than zero. See rce.ServerConfig.QueueCommands for what happens
+	// to commands over the limit.
+	MaxConcurrent int `yaml:"max_concurrent"`
+
+	// Optional lock names. A command cannot run while another command holding
+	// any of the same locks is running. For example, commands that use dpkg can
+	// all have lock "dpkg" so they never overlap. See rce.ServerConfig.QueueCommands
+	// for what happens to commands that cannot acquire their locks.
+	Locks []string `yaml:"locks"`
+
+	// Optional human-readable metadata returned to clients. Description is what
+	// the command does, Usage is how to use it (like args and params), and Owner
+	// is the team or person responsible for it. Tags group commands for clients
+	// and for policy like rce.ACL. See Runnable.FindByTag.
+	Description string   `yaml:"description"`
+	Usage       string   `yaml:"usage"`
+	Owner       string   `yaml:"owner"`
+	Tags        []string `yaml:"tags"`
+
+	cred *credential // resolved User, Group, and Groups
+}
+
+// ValidateMaxConcurrent returns ErrNegativeMax if the Spec's max concurrent is negative.
+func (c Spec) ValidateMaxConcurrent() error {
+	if c.MaxConcurrent < 0 {
+		return ErrNegativeMax
+	}
+	return nil
+}
+
+// ValidateLocks returns ErrInvalidLock if a lock name is empty or duplicated.
+func (c Spec) ValidateLocks() error {
+	seen := map[string]bool{}
+	for _, lock := range c.Locks {
+		if lock == "" || seen[lock] {
+			return ErrInvalidLock
+		}
+		seen[lock] = true
+	}
+	return nil
+}
+
+// ValidateTimeout returns ErrNegativeTimeout if the Spec's timeout is negative,
+// or ErrShortTimeout if it is set but less than 1s. In a YAML file, a bare
+// number is read as nanoseconds, so "timeout: 30" returns ErrShortTimeout.
+func (c Spec) ValidateTimeout() error {
+	if c.Timeout < 0 {
+		return ErrNegativeTimeout
+	}
+	if c.Timeout > 0 && c.Timeout < time.Second {
+		return ErrShortTimeout
+	}
+	return nil
 }
 
 // ValidateAbsPath returns ErrRelativePath if the Spec's path is not an absolute path.
@@ -85,22 +462,57 @@ func (c Spec) Args() []string {
 type Runnable []Spec
 
 type specFile struct {
+	CleanEnv bool     `yaml:"clean_env"`
 	Commands Runnable `yaml:"commands"`
 }
 
 // LoadCommands loads all command Spec from a YAML config file. The file structure is:
 //
-//	  ---
-//	  commands:
-//	    - name: exit.zero
-//	      exec: [/usr/bin/true]
-//		- name: exit.one
-//		  exec:
-//	        - /bin/false
-//	        - some-arg
+//	---
+//	commands:
+//	  - name: exit.zero
+//	    exec: [/usr/bin/true]
+//	    description: Exit zero
+//	    usage: exit.zero (no args)
+//	    owner: sre
+//	    tags: [test]
+//	  - name: exit.one
+//	    exec:
+//	      - /bin/false
+//	      - some-arg
+//	    timeout: 10s
+//	    max_concurrent: 1
+//	    locks: [dpkg]
+//	  - name: env
+//	    exec: [/usr/bin/env]
+//	    env:
+//	      LANG: C
+//	    inherit_env: [PATH, HOME]
+//	    dir: /tmp
+//	    umask: 0027
+//	    nice: 10
+//	    ionice_class: idle
+//	    user: nobody
+//	    group: nogroup
+//	  - name: ls
+//	    exec: [/bin/ls]
+//	    args:
+//	      max: 1
+//	      match: ["/tmp/[a-z]+"]
+//	  - name: restart
+//	    exec: [/usr/sbin/service, "{{service}}", restart]
+//	    params:
+//	      - name: service
+//	        type: enum
+//	        values: [nginx, mysql]
 //
 // Name must be unique. The first exec value must be an absolute command path.
 // Additional exec values are optional and always included in the order listed.
+// All other fields are optional; see Spec.
+//
+// If clean_env is true at the top level of the file (next to commands), commands
+// that do not set inherit_env inherit no variables from the agent, so their
+// environment is only what env sets.
 func LoadCommands(file string) (Runnable, error) {
 	bytes, err := ioutil.ReadFile(file)
 	if err != nil {
@@ -116,6 +528,14 @@ func LoadCommands(file string) (Runnable, error) {
 		return Runnable{}, ErrNoCommands
 	}
 
+	if s.CleanEnv {
+		for i := range s.Commands {
+			if s.Commands[i].InheritEnv == nil {
+				s.Commands[i].InheritEnv = &InheritEnv{}
+			}
+		}
+	}
+
 	if err := s.Commands.Validate(); err != nil {
 		return Runnable{}, err
 	}
@@ -124,6 +544,7 @@ func LoadCommands(file string) (Runnable, error) {
 }
 
 // Validate validates a list of Spec and returns an error if any invalid.
+// It also resolves the user and group names of each Spec; see ResolveCredential.
 func (r Runnable) Validate() error {
 	var err error
 
@@ -132,11 +553,51 @@ func (r Runnable) Validate() error {
 		return err
 	}
 
-	for _, c := range r {
+	for i, c := range r {
 		err = c.ValidateAbsPath()
 		if err != nil {
 			return err
 		}
+		err = c.ValidateTimeout()
+		if err != nil {
+			return err
+		}
+		err = c.ValidateMaxConcurrent()
+		if err != nil {
+			return err
+		}
+		err = c.ValidateLocks()
+		if err != nil {
+			return err
+		}
+		err = c.ValidateEnv()
+		if err != nil {
+			return err
+		}
+		err = c.ValidateDir()
+		if err != nil {
+			return err
+		}
+		err = c.ValidateUmask()
+		if err != nil {
+			return err
+		}
+		err = c.ValidatePriority()
+		if err != nil {
+			return err
+		}
+		err = c.ValidateArgPolicy()
+		if err != nil {
+			return err
+		}
+		err = c.ValidateParams()
+		if err != nil {
+			return err
+		}
+		err = r[i].ResolveCredential()
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -168,3 +629,37 @@ func (r Runnable) FindByName(name string) (Spec, error) {
 
 	return Spec{}, ErrCommandNotFound
 }
+
+// FindByTag returns all Spec with the given tag, in order, or an empty list
+// if none.
+func (r Runnable) FindByTag(tag string) Runnable {
+	found := Runnable{}
+	for _, c := range r {
+		for _, t := range c.Tags {
+			if t == tag {
+				found = append(found, c)
+				break
+			}
+		}
+	}
+	return found
+}
+
+// Diff returns the names of commands in r that are not in old (added), in old
+// that are not in r (removed), and in both but with a different Spec (changed).
+func (r Runnable) Diff(old Runnable) (added, removed, changed []string) {
+	for _, c := range r {
+		o, err := old.FindByName(c.Name)
+		if err != nil {
+			added = append(added, c.Name)
+		} else if !reflect.DeepEqual(c, o) {
+			changed = append(changed, c.Name)
+		}
+	}
+	for _, o := range old {
+		if _, err := r.FindByName(o.Name); err != nil {
+			removed = append(removed, o.Name)
+		}
+	}
+	return added, removed, changed
+}
@@ -3,7 +3,10 @@
 package cmd_test
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/go-test/deep"
 	"github.com/square/rce-agent/cmd"
@@ -43,10 +46,32 @@ func TestFindByName(t *testing.T) {
 	}
 }
 
+func TestFindByTag(t *testing.T) {
+	r, err := cmd.LoadCommands("../test/runnable-cmds.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string][]string{
+		"exit":    {"exit.zero", "exit.one"},
+		"test":    {"exit.zero"},
+		"missing": {},
+	}
+	for tag, expect := range tests {
+		got := []string{}
+		for _, c := range r.FindByTag(tag) {
+			got = append(got, c.Name)
+		}
+		if diff := deep.Equal(got, expect); diff != nil {
+			t.Errorf("%s: %v", tag, diff)
+		}
+	}
+}
+
 func TestValidateNoDuplicates(t *testing.T) {
 	good := cmd.Runnable{
-		cmd.Spec{"one", []string{}},
-		cmd.Spec{"two", []string{}},
+		cmd.Spec{Name: "one", Exec: []string{}},
+		cmd.Spec{Name: "two", Exec: []string{}},
 	}
 
 	err = good.ValidateNoDuplicates()
@@ -55,8 +80,8 @@ func TestValidateNoDuplicates(t *testing.T) {
 	}
 
 	bad := cmd.Runnable{
-		cmd.Spec{"one", []string{}},
-		cmd.Spec{"one", []string{}},
+		cmd.Spec{Name: "one", Exec: []string{}},
+		cmd.Spec{Name: "one", Exec: []string{}},
 	}
 
 	err = bad.ValidateNoDuplicates()
@@ -66,8 +91,8 @@ func TestValidateNoDuplicates(t *testing.T) {
 }
 
 func TestValidateAbsPath(t *testing.T) {
-	good := cmd.Spec{"good", []string{"/bin/ls"}}
-	bad := cmd.Spec{"bad", []string{"./bin/tr"}}
+	good := cmd.Spec{Name: "good", Exec: []string{"/bin/ls"}}
+	bad := cmd.Spec{Name: "bad", Exec: []string{"./bin/tr"}}
 
 	if good.ValidateAbsPath() != nil {
 		t.Error("expected good validation failed")
@@ -85,12 +110,18 @@ func TestLoadCommands(t *testing.T) {
 	}
 	expect := cmd.Runnable{
 		cmd.Spec{
-			Name: "exit.zero",
-			Exec: []string{"/usr/bin/true"},
+			Name:        "exit.zero",
+			Exec:        []string{"/usr/bin/true"},
+			Description: "Exit zero",
+			Usage:       "exit.zero (no args)",
+			Owner:       "sre",
+			Tags:        []string{"test", "exit"},
 		},
 		cmd.Spec{
-			Name: "exit.one",
-			Exec: []string{"/bin/false", "some-arg"},
+			Name:    "exit.one",
+			Exec:    []string{"/bin/false", "some-arg"},
+			Timeout: 10 * time.Second,
+			Tags:    []string{"exit"},
 		},
 	}
 	diff := deep.Equal(got, expect)
@@ -98,3 +129,126 @@ func TestLoadCommands(t *testing.T) {
 		t.Error(diff)
 	}
 }
+
+func TestValidateTimeout(t *testing.T) {
+	good := cmd.Spec{Name: "good", Exec: []string{"/bin/ls"}, Timeout: time.Second}
+	bad := cmd.Spec{Name: "bad", Exec: []string{"/bin/ls"}, Timeout: -time.Second}
+
+	if good.ValidateTimeout() != nil {
+		t.Error("expected good validation failed")
+	}
+
+	if bad.ValidateTimeout() != cmd.ErrNegativeTimeout {
+		t.Error("expected bad validation passed")
+	}
+
+	short := cmd.Spec{Name: "short", Exec: []string{"/bin/ls"}, Timeout: 500 * time.Millisecond}
+	if short.ValidateTimeout() != cmd.ErrShortTimeout {
+		t.Error("expected short validation passed")
+	}
+
+	// Bare number is nanoseconds, not seconds
+	file := filepath.Join(t.TempDir(), "commands.yaml")
+	yaml := "commands:\n  - name: bare\n    exec: [/bin/ls]\n    timeout: 30\n"
+	if err := os.WriteFile(file, []byte(yaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cmd.LoadCommands(file); err != cmd.ErrShortTimeout {
+		t.Errorf("got error %v, expected ErrShortTimeout", err)
+	}
+}
+
+func TestValidateMaxConcurrent(t *testing.T) {
+	good := cmd.Spec{Name: "good", Exec: []string{"/bin/ls"}, MaxConcurrent: 1}
+	bad := cmd.Spec{Name: "bad", Exec: []string{"/bin/ls"}, MaxConcurrent: -1}
+
+	if good.ValidateMaxConcurrent() != nil {
+		t.Error("expected good validation failed")
+	}
+
+	if bad.ValidateMaxConcurrent() != cmd.ErrNegativeMax {
+		t.Error("expected bad validation passed")
+	}
+}
+
+func TestStopBeforeStart(t *testing.T) {
+	c := cmd.NewCmd(cmd.Spec{Name: "true", Exec: []string{"/usr/bin/true"}}, nil)
+	if err := c.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-c.Done():
+	default:
+		t.Fatal("not done after Stop")
+	}
+	c.Start() // no-op
+
+	status := c.Status()
+	if status.StartTs != 0 || status.StopTs == 0 || status.Exit != -1 {
+		t.Errorf("got status %+v, expected StartTs 0, StopTs > 0, Exit -1", status)
+	}
+	if !c.Stopped() {
+		t.Error("Stopped is false, expected true")
+	}
+}
+
+func TestValidateLocks(t *testing.T) {
+	good := cmd.Spec{Name: "good", Exec: []string{"/bin/ls"}, Locks: []string{"apt", "dpkg"}}
+	if good.ValidateLocks() != nil {
+		t.Error("expected good validation failed")
+	}
+
+	for _, locks := range [][]string{{""}, {"dpkg", "dpkg"}} {
+		bad := cmd.Spec{Name: "bad", Exec: []string{"/bin/ls"}, Locks: locks}
+		if bad.ValidateLocks() != cmd.ErrInvalidLock {
+			t.Errorf("expected bad validation passed: %v", locks)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	old := cmd.Runnable{
+		{Name: "same", Exec: []string{"/bin/true"}},
+		{Name: "changed", Exec: []string{"/bin/true"}},
+		{Name: "removed", Exec: []string{"/bin/true"}},
+	}
+	r := cmd.Runnable{
+		{Name: "same", Exec: []string{"/bin/true"}},
+		{Name: "changed", Exec: []string{"/bin/true"}, Timeout: time.Second},
+		{Name: "added", Exec: []string{"/bin/true"}},
+	}
+	added, removed, changed := r.Diff(old)
+	if diff := deep.Equal(added, []string{"added"}); diff != nil {
+		t.Error(diff)
+	}
+	if diff := deep.Equal(removed, []string{"removed"}); diff != nil {
+		t.Error(diff)
+	}
+	if diff := deep.Equal(changed, []string{"changed"}); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestUnobserved(t *testing.T) {
+	c := cmd.NewCmd(cmd.Spec{Name: "true", Exec: []string{"/usr/bin/true"}}, nil)
+	time.Sleep(20 * time.Millisecond)
+	if d := c.Unobserved(); d < 20*time.Millisecond {
+		t.Errorf("got unobserved %s, expected >= 20ms", d)
+	}
+
+	done := c.Watch()
+	time.Sleep(20 * time.Millisecond)
+	if d := c.Unobserved(); d != 0 {
+		t.Errorf("got unobserved %s while watched, expected 0", d)
+	}
+	done()
+	if d := c.Unobserved(); d >= 20*time.Millisecond {
+		t.Errorf("got unobserved %s after watch, expected < 20ms", d)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	c.Observe()
+	if d := c.Unobserved(); d >= 20*time.Millisecond {
+		t.Errorf("got unobserved %s after observe, expected < 20ms", d)
+	}
+}
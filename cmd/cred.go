@@ -0,0 +1,99 @@
+// Copyright 2017-2023 Block, Inc.
+
+package cmd
+
+import (
+	"errors"
+	"os"
+	"os/user"
+	"strconv"
+)
+
+var (
+	ErrUnknownUser  = errors.New("command user not found")
+	ErrUnknownGroup = errors.New("command group not found")
+)
+
+// credential is the resolved user, group, and supplementary groups of a Spec.
+type credential struct {
+	uid    uint32
+	gid    uint32
+	groups []uint32
+}
+
+// ResolveCredential looks up the Spec's user, group, and supplementary groups
+// and saves their IDs for running the command. It returns ErrUnknownUser or
+// ErrUnknownGroup if any name (or numeric ID) is not found. It is called by
+// Runnable.Validate, so names are resolved when commands are loaded. If not
+// called, names are resolved when the command is started.
+func (c *Spec) ResolveCredential() error {
+	c.cred = nil
+	if c.User == "" && c.Group == "" && len(c.Groups) == 0 {
+		return nil
+	}
+
+	cred := &credential{
+		uid: uint32(os.Getuid()),
+		gid: uint32(os.Getgid()),
+	}
+
+	var u *user.User
+	if c.User != "" {
+		var err error
+		if u, err = lookupUser(c.User); err != nil {
+			return ErrUnknownUser
+		}
+		cred.uid = parseID(u.Uid)
+		cred.gid = parseID(u.Gid)
+	}
+
+	if c.Group != "" {
+		g, err := lookupGroup(c.Group)
+		if err != nil {
+			return ErrUnknownGroup
+		}
+		cred.gid = parseID(g.Gid)
+	}
+
+	cred.groups = []uint32{}
+	if len(c.Groups) > 0 {
+		for _, name := range c.Groups {
+			g, err := lookupGroup(name)
+			if err != nil {
+				return ErrUnknownGroup
+			}
+			cred.groups = append(cred.groups, parseID(g.Gid))
+		}
+	} else if u != nil {
+		// Like login, the user's groups are the default supplementary groups
+		gids, err := u.GroupIds()
+		if err != nil {
+			return ErrUnknownGroup
+		}
+		for _, gid := range gids {
+			cred.groups = append(cred.groups, parseID(gid))
+		}
+	}
+
+	c.cred = cred
+	return nil
+}
+
+func lookupUser(name string) (*user.User, error) {
+	if _, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return user.LookupId(name)
+	}
+	return user.Lookup(name)
+}
+
+func lookupGroup(name string) (*user.Group, error) {
+	if _, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return user.LookupGroupId(name)
+	}
+	return user.LookupGroup(name)
+}
+
+func parseID(id string) uint32 {
+	n, _ := strconv.ParseUint(id, 10, 32)
+	return uint32(n)
+}
@@ -0,0 +1,16 @@
+// Copyright 2017-2023 Block, Inc.
+
+//go:build !unix
+
+package cmd
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// setCredential returns an error because user and group are only supported
+// on Unix.
+func setCredential(cmd *exec.Cmd, cred *credential) error {
+	return errors.New("user and group are only supported on Unix")
+}
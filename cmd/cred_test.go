@@ -0,0 +1,48 @@
+// Copyright 2017-2023 Block, Inc.
+
+package cmd_test
+
+import (
+	"os/user"
+	"testing"
+
+	"github.com/square/rce-agent/cmd"
+)
+
+func TestResolveCredential(t *testing.T) {
+	u, err := user.Current()
+	if err != nil {
+		t.Skip(err)
+	}
+	g, err := user.LookupGroupId(u.Gid)
+	if err != nil {
+		t.Skip(err)
+	}
+
+	good := []cmd.Spec{
+		{},
+		{User: u.Username},
+		{User: u.Uid, Group: g.Name},
+		{Group: u.Gid, Groups: []string{g.Name}},
+	}
+	for _, spec := range good {
+		if err := spec.ResolveCredential(); err != nil {
+			t.Errorf("%+v: got error %s, expected nil", spec, err)
+		}
+	}
+
+	spec := cmd.Spec{User: "rce-agent-no-such-user"}
+	if err := spec.ResolveCredential(); err != cmd.ErrUnknownUser {
+		t.Errorf("got error %v, expected ErrUnknownUser", err)
+	}
+
+	spec = cmd.Spec{Groups: []string{g.Name, "rce-agent-no-such-group"}}
+	if err := spec.ResolveCredential(); err != cmd.ErrUnknownGroup {
+		t.Errorf("got error %v, expected ErrUnknownGroup", err)
+	}
+
+	r := cmd.Runnable{{Name: "bad", Exec: []string{"/usr/bin/id"}, User: "rce-agent-no-such-user"}}
+	if err := r.Validate(); err != cmd.ErrUnknownUser {
+		t.Errorf("Validate returned error %v, expected ErrUnknownUser", err)
+	}
+}
@@ -0,0 +1,23 @@
+// Copyright 2017-2023 Block, Inc.
+
+//go:build unix
+
+package cmd
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setCredential makes the command run as the credential user and groups.
+func setCredential(cmd *exec.Cmd, cred *credential) error {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = &syscall.Credential{
+		Uid:    cred.uid,
+		Gid:    cred.gid,
+		Groups: cred.groups,
+	}
+	return nil
+}
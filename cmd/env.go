@@ -0,0 +1,89 @@
+// Copyright 2017-2023 Block, Inc.
+
+package cmd
+
+import (
+	"errors"
+	"os"
+	"sort"
+	"strings"
+)
+
+var (
+	ErrInvalidEnv        = errors.New("invalid environment variable name")
+	ErrInvalidInheritEnv = errors.New("inherit_env must be true, false, or a list of variable names")
+)
+
+// InheritEnv specifies which environment variables of the agent a command
+// inherits. In YAML, it is either a bool (true = inherit all, false = inherit
+// none) or a list of variable names to inherit.
+type InheritEnv struct {
+	All   bool     // inherit all variables
+	Names []string // inherit only these variables, if All is false
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (e *InheritEnv) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var all bool
+	if err := unmarshal(&all); err == nil {
+		*e = InheritEnv{All: all}
+		return nil
+	}
+	var names []string
+	if err := unmarshal(&names); err != nil {
+		return ErrInvalidInheritEnv
+	}
+	*e = InheritEnv{Names: names}
+	return nil
+}
+
+// Environ returns the environment of the command as "key=value" strings:
+// inherited variables first, then Env variables sorted by name. It returns
+// nil if neither Env nor InheritEnv is set, which means the command inherits
+// the agent's entire environment.
+func (c Spec) Environ() []string {
+	if c.InheritEnv == nil && len(c.Env) == 0 {
+		return nil
+	}
+
+	env := []string{}
+	switch {
+	case c.InheritEnv == nil || c.InheritEnv.All:
+		env = append(env, os.Environ()...)
+	default:
+		for _, name := range c.InheritEnv.Names {
+			if val, ok := os.LookupEnv(name); ok {
+				env = append(env, name+"="+val)
+			}
+		}
+	}
+
+	names := make([]string, 0, len(c.Env))
+	for name := range c.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		env = append(env, name+"="+c.Env[name])
+	}
+
+	return env
+}
+
+// ValidateEnv returns ErrInvalidEnv if any Env or InheritEnv variable name
+// is empty or contains "=".
+func (c Spec) ValidateEnv() error {
+	names := []string{}
+	for name := range c.Env {
+		names = append(names, name)
+	}
+	if c.InheritEnv != nil {
+		names = append(names, c.InheritEnv.Names...)
+	}
+	for _, name := range names {
+		if name == "" || strings.Contains(name, "=") {
+			return ErrInvalidEnv
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+// Copyright 2017-2023 Block, Inc.
+
+package cmd_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/square/rce-agent/cmd"
+)
+
+func TestLoadCommandsEnv(t *testing.T) {
+	got, err := cmd.LoadCommands("../test/env-cmds.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := cmd.Runnable{
+		cmd.Spec{
+			Name:       "clean",
+			Exec:       []string{"/usr/bin/env"},
+			InheritEnv: &cmd.InheritEnv{}, // clean_env: true
+		},
+		cmd.Spec{
+			Name:       "some",
+			Exec:       []string{"/usr/bin/env"},
+			Env:        map[string]string{"FOO": "bar"},
+			InheritEnv: &cmd.InheritEnv{Names: []string{"RCE_TEST_INHERIT"}},
+		},
+		cmd.Spec{
+			Name:       "all",
+			Exec:       []string{"/usr/bin/env"},
+			InheritEnv: &cmd.InheritEnv{All: true},
+		},
+	}
+	if diff := deep.Equal(got, expect); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestEnviron(t *testing.T) {
+	t.Setenv("RCE_TEST_INHERIT", "yes")
+	t.Setenv("RCE_TEST_SECRET", "no")
+
+	// Default: inherit everything, same as os/exec
+	spec := cmd.Spec{Name: "default", Exec: []string{"/usr/bin/env"}}
+	if env := spec.Environ(); env != nil {
+		t.Errorf("got env %v, expected nil", env)
+	}
+
+	// Clean: nothing
+	spec.InheritEnv = &cmd.InheritEnv{}
+	if diff := deep.Equal(spec.Environ(), []string{}); diff != nil {
+		t.Error(diff)
+	}
+
+	// Only named variables, then Env sorted by name
+	spec.InheritEnv = &cmd.InheritEnv{Names: []string{"RCE_TEST_INHERIT", "RCE_TEST_NOT_SET"}}
+	spec.Env = map[string]string{"Z": "1", "A": "2"}
+	expect := []string{"RCE_TEST_INHERIT=yes", "A=2", "Z=1"}
+	if diff := deep.Equal(spec.Environ(), expect); diff != nil {
+		t.Error(diff)
+	}
+
+	// All variables, then Env
+	spec.InheritEnv = &cmd.InheritEnv{All: true}
+	expect = append(os.Environ(), "A=2", "Z=1")
+	if diff := deep.Equal(spec.Environ(), expect); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestValidateEnv(t *testing.T) {
+	good := cmd.Spec{Name: "good", Env: map[string]string{"FOO": "bar"}}
+	if err := good.ValidateEnv(); err != nil {
+		t.Errorf("expected good validation failed: %s", err)
+	}
+
+	bad := cmd.Spec{Name: "bad", Env: map[string]string{"FOO=": "bar"}}
+	if err := bad.ValidateEnv(); err != cmd.ErrInvalidEnv {
+		t.Errorf("got error %v, expected ErrInvalidEnv", err)
+	}
+
+	bad = cmd.Spec{Name: "bad", InheritEnv: &cmd.InheritEnv{Names: []string{""}}}
+	if err := bad.ValidateEnv(); err != cmd.ErrInvalidEnv {
+		t.Errorf("got error %v, expected ErrInvalidEnv", err)
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2017-2023 Block, Inc.
+
+package cmd
+
+import (
+	"sync"
+)
+
+// Line is one line of STDOUT or STDERR output from a command.
+type Line struct {
+	Stderr bool   // true if line is from STDERR, else STDOUT
+	Text   string // line without trailing newline
+}
+
+// output is a thread-safe, append-only log of command output lines. It allows
+// any number of readers to follow the output as it is produced.
+type output struct {
+	*sync.Mutex
+	lines  []Line
+	done   bool          // no more lines will be added
+	notify chan struct{} // closed and replaced when lines added or done
+}
+
+func newOutput() *output {
+	return &output{
+		Mutex:  &sync.Mutex{},
+		lines:  []Line{},
+		notify: make(chan struct{}),
+	}
+}
+
+func (o *output) add(line Line) {
+	o.Lock()
+	defer o.Unlock()
+	o.lines = append(o.lines, line)
+	close(o.notify)
+	o.notify = make(chan struct{})
+}
+
+func (o *output) close() {
+	o.Lock()
+	defer o.Unlock()
+	o.done = true
+	close(o.notify)
+	o.notify = make(chan struct{})
+}
+
+func (o *output) since(i int) ([]Line, <-chan struct{}, bool) {
+	o.Lock()
+	defer o.Unlock()
+	if i > len(o.lines) {
+		i = len(o.lines)
+	}
+	lines := make([]Line, len(o.lines)-i)
+	copy(lines, o.lines[i:])
+	return lines, o.notify, o.done
+}
+
+func (o *output) split() (stdout, stderr []string) {
+	o.Lock()
+	defer o.Unlock()
+	stdout = []string{}
+	stderr = []string{}
+	for _, line := range o.lines {
+		if line.Stderr {
+			stderr = append(stderr, line.Text)
+		} else {
+			stdout = append(stdout, line.Text)
+		}
+	}
+	return stdout, stderr
+}
@@ -0,0 +1,231 @@
+// Copyright 2017-2023 Block, Inc.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidParam      = errors.New("invalid parameter spec")
+	ErrUnknownParam      = errors.New("unknown parameter")
+	ErrMissingParam      = errors.New("missing required parameter")
+	ErrInvalidParamValue = errors.New("invalid parameter value")
+)
+
+// Parameter types for Param.Type.
+const (
+	ParamString   = "string"
+	ParamInt      = "int"
+	ParamEnum     = "enum"
+	ParamHostname = "hostname"
+	ParamPath     = "path"
+)
+
+// Param is a named, typed command parameter. Clients pass parameters by name,
+// and the values replace "{{name}}" placeholders in Spec.Exec. In YAML:
+//
+//	exec: [/usr/sbin/service, "{{service}}", restart]
+//	params:
+//	  - name: service
+//	    type: enum
+//	    values: [nginx, mysql]
+//
+// A parameter is required unless it has a default value. Type is one of:
+//
+//	string    any value not starting with "-", or only values that match pattern
+//	          (the whole value must match; a pattern can allow a leading "-")
+//	int       integer, optionally between min and max (inclusive)
+//	enum      one of values
+//	hostname  valid DNS hostname (RFC 1123)
+//	path      absolute, clean file path, optionally under prefix
+//
+// Type defaults to string.
+type Param struct {
+	Name    string   `yaml:"name"`
+	Type    string   `yaml:"type"`
+	Default *string  `yaml:"default"`
+	Pattern string   `yaml:"pattern"` // string
+	Min     *int64   `yaml:"min"`     // int
+	Max     *int64   `yaml:"max"`     // int
+	Values  []string `yaml:"values"`  // enum
+	Prefix  string   `yaml:"prefix"`  // path
+
+	pattern *regexp.Regexp // compiled Pattern
+}
+
+// ParamError describes a client parameter that is unknown, missing, or invalid.
+type ParamError struct {
+	Name  string // parameter name
+	Value string // parameter value, if any
+	Err   error  // ErrUnknownParam, ErrMissingParam, or ErrInvalidParamValue
+}
+
+func (e *ParamError) Error() string {
+	if e.Err == ErrInvalidParamValue {
+		return fmt.Sprintf("%s: %s: %q", e.Err, e.Name, e.Value)
+	}
+	return fmt.Sprintf("%s: %s", e.Err, e.Name)
+}
+
+func (e *ParamError) Unwrap() error {
+	return e.Err
+}
+
+var (
+	placeholderRe = regexp.MustCompile(`{{\s*([^{}\s]*)\s*}}`)
+	paramNameRe   = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_.-]*$`)
+	hostnameRe    = regexp.MustCompile(`^([a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)(\.([a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?))*$`)
+)
+
+// ValidateParams returns ErrInvalidParam if any Param is invalid (bad name,
+// type, or default value), a Param name is duplicated, or Exec has a
+// placeholder for an undeclared Param. The command path cannot have
+// placeholders.
+func (c Spec) ValidateParams() error {
+	params := map[string]bool{}
+	for i := range c.Params {
+		p := &c.Params[i]
+		if !paramNameRe.MatchString(p.Name) || params[p.Name] {
+			return ErrInvalidParam
+		}
+		params[p.Name] = true
+
+		switch p.Type {
+		case "", ParamString, ParamInt, ParamHostname:
+		case ParamEnum:
+			if len(p.Values) == 0 {
+				return ErrInvalidParam
+			}
+		case ParamPath:
+			if p.Prefix != "" && !filepath.IsAbs(p.Prefix) {
+				return ErrInvalidParam
+			}
+		default:
+			return ErrInvalidParam
+		}
+
+		if p.Pattern != "" {
+			re, err := regexp.Compile("^(?:" + p.Pattern + ")$")
+			if err != nil {
+				return ErrInvalidParam
+			}
+			p.pattern = re
+		}
+
+		if p.Default != nil {
+			if err := p.Validate(*p.Default); err != nil {
+				return ErrInvalidParam
+			}
+		}
+	}
+
+	for i, arg := range c.Exec {
+		for _, m := range placeholderRe.FindAllStringSubmatch(arg, -1) {
+			if i == 0 || !params[m[1]] {
+				return ErrInvalidParam
+			}
+		}
+	}
+
+	return nil
+}
+
+// Validate returns ErrInvalidParamValue if the value is not valid for the Param
+// type. A string value without a pattern cannot start with "-" so that it cannot
+// be passed to the command as an option.
+func (p Param) Validate(value string) error {
+	switch p.Type {
+	case "", ParamString:
+		re := p.pattern
+		if re == nil && p.Pattern != "" {
+			var err error
+			if re, err = regexp.Compile("^(?:" + p.Pattern + ")$"); err != nil {
+				return ErrInvalidParam
+			}
+		}
+		if re != nil && !re.MatchString(value) {
+			return ErrInvalidParamValue
+		}
+		if re == nil && strings.HasPrefix(value, "-") {
+			return ErrInvalidParamValue
+		}
+	case ParamInt:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || (p.Min != nil && n < *p.Min) || (p.Max != nil && n > *p.Max) {
+			return ErrInvalidParamValue
+		}
+	case ParamEnum:
+		if !contains(p.Values, value) {
+			return ErrInvalidParamValue
+		}
+	case ParamHostname:
+		if len(value) > 253 || !hostnameRe.MatchString(value) {
+			return ErrInvalidParamValue
+		}
+	case ParamPath:
+		if !filepath.IsAbs(value) || filepath.Clean(value) != value || strings.ContainsRune(value, 0) {
+			return ErrInvalidParamValue
+		}
+		if p.Prefix != "" {
+			prefix := filepath.Clean(p.Prefix)
+			if value != prefix && !strings.HasPrefix(value, strings.TrimSuffix(prefix, "/")+"/") {
+				return ErrInvalidParamValue
+			}
+		}
+	default:
+		return ErrInvalidParam
+	}
+	return nil
+}
+
+// Expand returns Exec with parameter placeholders replaced by the given client
+// parameter values or, for parameters not given, their default values. It
+// returns a *ParamError if a parameter is unknown, missing (required but not
+// given), or its value is invalid for its type.
+func (c Spec) Expand(params map[string]string) ([]string, error) {
+	values := map[string]string{}
+	for name, value := range params {
+		if _, ok := c.findParam(name); !ok {
+			return nil, &ParamError{Name: name, Value: value, Err: ErrUnknownParam}
+		}
+	}
+	for _, p := range c.Params {
+		value, ok := params[p.Name]
+		if !ok {
+			if p.Default == nil {
+				return nil, &ParamError{Name: p.Name, Err: ErrMissingParam}
+			}
+			value = *p.Default
+		}
+		if err := p.Validate(value); err != nil {
+			return nil, &ParamError{Name: p.Name, Value: value, Err: err}
+		}
+		values[p.Name] = value
+	}
+
+	if len(values) == 0 {
+		return c.Exec, nil
+	}
+	exec := make([]string, len(c.Exec))
+	for i, arg := range c.Exec {
+		exec[i] = placeholderRe.ReplaceAllStringFunc(arg, func(s string) string {
+			return values[placeholderRe.FindStringSubmatch(s)[1]]
+		})
+	}
+	return exec, nil
+}
+
+func (c Spec) findParam(name string) (Param, bool) {
+	for _, p := range c.Params {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return Param{}, false
+}
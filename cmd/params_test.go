@@ -0,0 +1,142 @@
+// Copyright 2017-2023 Block, Inc.
+
+package cmd_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/square/rce-agent/cmd"
+)
+
+func TestExpand(t *testing.T) {
+	r, err := cmd.LoadCommands("../test/server-test-commands.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec, err := r.FindByName("greet")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Default count
+	got, err := spec.Expand(map[string]string{"name": "world"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(got, []string{"/bin/echo", "hello world", "x1"}); diff != nil {
+		t.Error(diff)
+	}
+
+	got, err = spec.Expand(map[string]string{"name": "there", "count": "3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(got, []string{"/bin/echo", "hello there", "x3"}); diff != nil {
+		t.Error(diff)
+	}
+
+	// Spec is not modified
+	if diff := deep.Equal(spec.Exec, []string{"/bin/echo", "hello {{name}}", "x{{count}}"}); diff != nil {
+		t.Error(diff)
+	}
+
+	tests := []struct {
+		params map[string]string
+		name   string
+		err    error
+	}{
+		{map[string]string{}, "name", cmd.ErrMissingParam},
+		{map[string]string{"name": "world", "other": "x"}, "other", cmd.ErrUnknownParam},
+		{map[string]string{"name": "; rm -rf /"}, "name", cmd.ErrInvalidParamValue},
+		{map[string]string{"name": "world", "count": "4"}, "count", cmd.ErrInvalidParamValue},
+		{map[string]string{"name": "world", "count": "one"}, "count", cmd.ErrInvalidParamValue},
+	}
+	for _, tt := range tests {
+		_, err := spec.Expand(tt.params)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%v: got error %v, expected %v", tt.params, err, tt.err)
+			continue
+		}
+		var paramErr *cmd.ParamError
+		if !errors.As(err, &paramErr) || paramErr.Name != tt.name {
+			t.Errorf("%v: got error %v, expected error for parameter %s", tt.params, err, tt.name)
+		}
+	}
+
+	// String value cannot be an option
+	spec = cmd.Spec{Name: "sleep", Exec: []string{"/bin/sleep", "{{s}}"}, Params: []cmd.Param{{Name: "s"}}}
+	if _, err := spec.Expand(map[string]string{"s": "--help"}); !errors.Is(err, cmd.ErrInvalidParamValue) {
+		t.Errorf("got error %v, expected ErrInvalidParamValue", err)
+	}
+}
+
+func TestParamValidate(t *testing.T) {
+	min := int64(-1)
+	tests := []struct {
+		param cmd.Param
+		good  []string
+		bad   []string
+	}{
+		{
+			cmd.Param{Type: cmd.ParamString, Pattern: "[a-z]+"},
+			[]string{"abc"},
+			[]string{"", "abc1", "-a"},
+		},
+		{
+			cmd.Param{Type: cmd.ParamString},
+			[]string{"", "abc", "a-b", "a b"},
+			[]string{"-a", "--help", "-"},
+		},
+		{
+			cmd.Param{Type: cmd.ParamString, Pattern: "-[a-z]"},
+			[]string{"-a"},
+			[]string{"a", "--help"},
+		},
+		{
+			cmd.Param{Type: cmd.ParamInt, Min: &min},
+			[]string{"-1", "0", "100"},
+			[]string{"-2", "1.5", "0x10"},
+		},
+		{
+			cmd.Param{Type: cmd.ParamHostname},
+			[]string{"localhost", "db-1.example.com"},
+			[]string{"-oProxyCommand=x", "a..b", "host_name", ""},
+		},
+		{
+			cmd.Param{Type: cmd.ParamPath, Prefix: "/var/log"},
+			[]string{"/var/log", "/var/log/syslog"},
+			[]string{"/var/logs", "/var/log/../../etc/passwd", "var/log/syslog", "/etc/passwd"},
+		},
+	}
+	for _, tt := range tests {
+		for _, v := range tt.good {
+			if err := tt.param.Validate(v); err != nil {
+				t.Errorf("%s %q: got error %s, expected nil", tt.param.Type, v, err)
+			}
+		}
+		for _, v := range tt.bad {
+			if err := tt.param.Validate(v); err != cmd.ErrInvalidParamValue {
+				t.Errorf("%s %q: got error %v, expected ErrInvalidParamValue", tt.param.Type, v, err)
+			}
+		}
+	}
+}
+
+func TestValidateParams(t *testing.T) {
+	bad := "x"
+	specs := []cmd.Spec{
+		{Exec: []string{"/bin/echo", "{{undeclared}}"}},
+		{Exec: []string{"/bin/{{p}}"}, Params: []cmd.Param{{Name: "p"}}},
+		{Exec: []string{"/bin/echo"}, Params: []cmd.Param{{Name: "p"}, {Name: "p"}}},
+		{Exec: []string{"/bin/echo"}, Params: []cmd.Param{{Name: "p", Type: "float"}}},
+		{Exec: []string{"/bin/echo"}, Params: []cmd.Param{{Name: "p", Type: cmd.ParamEnum}}},
+		{Exec: []string{"/bin/echo"}, Params: []cmd.Param{{Name: "p", Type: cmd.ParamInt, Default: &bad}}},
+	}
+	for _, spec := range specs {
+		if err := spec.ValidateParams(); err != cmd.ErrInvalidParam {
+			t.Errorf("%+v: got error %v, expected ErrInvalidParam", spec, err)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2017-2023 Block, Inc.
+
+package cmd
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+var (
+	ErrInvalidDir    = errors.New("command dir is not an absolute path to a directory")
+	ErrInvalidUmask  = errors.New("command umask is not between 0 and 0777")
+	ErrInvalidNice   = errors.New("command nice level is not between -20 and 19")
+	ErrInvalidIONice = errors.New("command ionice class or level is invalid")
+
+	// ErrProcAttrUnsupported is returned by ValidateUmask and ValidatePriority
+	// if umask, nice, or ionice is set but the OS is not Linux.
+	ErrProcAttrUnsupported = errors.New("command umask, nice, and ionice are only supported on Linux")
+)
+
+// I/O scheduling classes for Spec.IONiceClass, same as ionice(1).
+const (
+	IONiceRealtime   = "realtime"
+	IONiceBestEffort = "best-effort"
+	IONiceIdle       = "idle"
+)
+
+// ValidateDir returns ErrInvalidDir if the Spec's dir is set but is not an
+// absolute path to an existing directory.
+func (c Spec) ValidateDir() error {
+	if c.Dir == "" {
+		return nil
+	}
+	if !filepath.IsAbs(c.Dir) {
+		return ErrInvalidDir
+	}
+	info, err := os.Stat(c.Dir)
+	if err != nil || !info.IsDir() {
+		return ErrInvalidDir
+	}
+	return nil
+}
+
+// ValidateUmask returns ErrInvalidUmask if the Spec's umask is set but out of
+// range, or ErrProcAttrUnsupported if it is set but the OS is not Linux.
+func (c Spec) ValidateUmask() error {
+	if c.Umask == nil {
+		return nil
+	}
+	if *c.Umask < 0 || *c.Umask > 0777 {
+		return ErrInvalidUmask
+	}
+	if !procAttrSupported {
+		return ErrProcAttrUnsupported
+	}
+	return nil
+}
+
+// ValidatePriority returns ErrInvalidNice or ErrInvalidIONice if the Spec's
+// nice level or ionice class and level are invalid, or ErrProcAttrUnsupported
+// if either is set but the OS is not Linux.
+func (c Spec) ValidatePriority() error {
+	if c.Nice < -20 || c.Nice > 19 {
+		return ErrInvalidNice
+	}
+	switch c.IONiceClass {
+	case "", IONiceIdle:
+		if c.IONiceLevel != 0 {
+			return ErrInvalidIONice
+		}
+	case IONiceRealtime, IONiceBestEffort:
+		if c.IONiceLevel < 0 || c.IONiceLevel > 7 {
+			return ErrInvalidIONice
+		}
+	default:
+		return ErrInvalidIONice
+	}
+	if (c.Nice != 0 || c.IONiceClass != "") && !procAttrSupported {
+		return ErrProcAttrUnsupported
+	}
+	return nil
+}
+
+// hasProcAttr returns true if the Spec sets process attributes that must be
+// applied by setProcAttr.
+func (c Spec) hasProcAttr() bool {
+	return c.Umask != nil || c.Nice != 0 || c.IONiceClass != ""
+}
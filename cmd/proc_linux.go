@@ -0,0 +1,60 @@
+// Copyright 2017-2023 Block, Inc.
+
+package cmd
+
+import (
+	"runtime"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// ioprio_set(2) values; see linux/ioprio.h.
+const (
+	ioprioClassShift = 13
+	ioprioWhoProcess = 1
+)
+
+// procAttrSupported is true because umask, nice, and ionice are supported.
+const procAttrSupported = true
+
+var ioprioClass = map[string]int{
+	IONiceRealtime:   1,
+	IONiceBestEffort: 2,
+	IONiceIdle:       3,
+}
+
+// setProcAttr sets the umask, nice level, and I/O priority of the Spec on the
+// current thread so that the command, which is forked from this thread next,
+// inherits them. These attributes are per-thread on Linux (umask after
+// unsharing CLONE_FS), so the goroutine is locked to the thread and never
+// unlocked: the thread is discarded when the goroutine exits (when the command
+// is done), so its attributes never affect the agent.
+func setProcAttr(s Spec) error {
+	runtime.LockOSThread()
+
+	tid := unix.Gettid()
+
+	if s.Umask != nil {
+		if err := unix.Unshare(unix.CLONE_FS); err != nil {
+			return err
+		}
+		syscall.Umask(*s.Umask)
+	}
+
+	if s.Nice != 0 {
+		if err := unix.Setpriority(unix.PRIO_PROCESS, tid, s.Nice); err != nil {
+			return err
+		}
+	}
+
+	if s.IONiceClass != "" {
+		prio := ioprioClass[s.IONiceClass]<<ioprioClassShift | s.IONiceLevel
+		_, _, errno := unix.Syscall(unix.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), uintptr(prio))
+		if errno != 0 {
+			return errno
+		}
+	}
+
+	return nil
+}
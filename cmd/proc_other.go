@@ -0,0 +1,15 @@
+// Copyright 2017-2023 Block, Inc.
+
+//go:build !linux
+
+package cmd
+
+// procAttrSupported is false because umask, nice, and ionice are only
+// supported on Linux.
+const procAttrSupported = false
+
+// setProcAttr returns ErrProcAttrUnsupported because umask, nice, and ionice
+// are only supported on Linux.
+func setProcAttr(s Spec) error {
+	return ErrProcAttrUnsupported
+}
@@ -0,0 +1,64 @@
+// Copyright 2017-2023 Block, Inc.
+
+package cmd_test
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/square/rce-agent/cmd"
+)
+
+func TestValidateDir(t *testing.T) {
+	for _, dir := range []string{"", "/tmp"} {
+		if err := (cmd.Spec{Dir: dir}).ValidateDir(); err != nil {
+			t.Errorf("dir %s: got error %s, expected nil", dir, err)
+		}
+	}
+	for _, dir := range []string{"tmp", "/does/not/exist", "/bin/sh"} {
+		if err := (cmd.Spec{Dir: dir}).ValidateDir(); err != cmd.ErrInvalidDir {
+			t.Errorf("dir %s: got error %v, expected ErrInvalidDir", dir, err)
+		}
+	}
+}
+
+func TestValidatePriority(t *testing.T) {
+	good := []cmd.Spec{
+		{Nice: 19},
+		{Nice: -20, IONiceClass: cmd.IONiceIdle},
+		{IONiceClass: cmd.IONiceBestEffort, IONiceLevel: 7},
+	}
+	var expect error
+	if runtime.GOOS != "linux" {
+		expect = cmd.ErrProcAttrUnsupported
+	}
+	for _, spec := range good {
+		if err := spec.ValidatePriority(); err != expect {
+			t.Errorf("%+v: got error %v, expected %v", spec, err, expect)
+		}
+	}
+	if err := (cmd.Spec{}).ValidatePriority(); err != nil {
+		t.Errorf("got error %s, expected nil", err)
+	}
+
+	bad := []cmd.Spec{
+		{Nice: 20},
+		{IONiceClass: "fast"},
+		{IONiceClass: cmd.IONiceRealtime, IONiceLevel: 8},
+		{IONiceLevel: 1},
+	}
+	for _, spec := range bad {
+		if err := spec.ValidatePriority(); err == nil {
+			t.Errorf("%+v: got nil error, expected an error", spec)
+		}
+	}
+
+	umask := 01000
+	if err := (cmd.Spec{Umask: &umask}).ValidateUmask(); err != cmd.ErrInvalidUmask {
+		t.Errorf("got error %v, expected ErrInvalidUmask", err)
+	}
+	umask = 022
+	if err := (cmd.Spec{Umask: &umask}).ValidateUmask(); err != expect {
+		t.Errorf("got error %v, expected %v", err, expect)
+	}
+}
@@ -0,0 +1,17 @@
+// Copyright 2017-2023 Block, Inc.
+
+//go:build !unix
+
+package cmd
+
+import (
+	"syscall"
+)
+
+// SignalName returns the name of a signal, or an empty string if sig is zero.
+func SignalName(sig syscall.Signal) string {
+	if sig == 0 {
+		return ""
+	}
+	return sig.String()
+}
@@ -0,0 +1,23 @@
+// Copyright 2017-2023 Block, Inc.
+
+//go:build unix
+
+package cmd
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// SignalName returns the name of a signal, like "SIGTERM", or an empty string
+// if sig is zero.
+func SignalName(sig syscall.Signal) string {
+	if sig == 0 {
+		return ""
+	}
+	if name := unix.SignalName(sig); name != "" {
+		return name
+	}
+	return sig.String()
+}
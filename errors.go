@@ -0,0 +1,178 @@
+// Copyright 2017-2023 Block, Inc.
+
+package rce
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Client errors. Client methods return an *Error that wraps one of these (or
+// one of the scheduler errors: ErrTooManyCommands, ErrTooManyInstances, or
+// ErrLocked), so use errors.Is to check the kind of error. Open returns an
+// error that wraps ErrAgentUnavailable if it cannot connect.
+var (
+	// ErrUnknownCommand is returned if the command name is not allowed by the
+	// agent.
+	ErrUnknownCommand = errors.New("unknown command")
+
+	// ErrInvalidArgs is returned if a command argument or parameter is not
+	// allowed or invalid. The *Error Violations describe which ones.
+	ErrInvalidArgs = errors.New("invalid command arguments or parameters")
+
+	// ErrNotFound is returned if the command ID is not found, usually because
+	// the command has been reaped.
+	ErrNotFound = errors.New("command ID not found")
+
+	// ErrPermissionDenied is returned if the client is not allowed to run the
+	// command (ServerConfig.ACL) or access the command ID (ServerConfig.RestrictToOwner).
+	ErrPermissionDenied = errors.New("permission denied")
+
+	// ErrAgentUnavailable is returned if the client cannot connect to the agent.
+	ErrAgentUnavailable = errors.New("agent unavailable")
+
+	// ErrHistoryDisabled is returned by History if the agent has no history.
+	ErrHistoryDisabled = errors.New("history is not enabled")
+)
+
+// errorDomain is the google.rpc.ErrorInfo domain of server errors.
+const errorDomain = "rce-agent"
+
+// google.rpc.ErrorInfo reasons of server errors, mapped to client errors.
+var reasonErrors = map[string]error{
+	"UNKNOWN_COMMAND":    ErrUnknownCommand,
+	"INVALID_ARGUMENTS":  ErrInvalidArgs,
+	"COMMAND_NOT_FOUND":  ErrNotFound,
+	"PERMISSION_DENIED":  ErrPermissionDenied,
+	"TOO_MANY_COMMANDS":  ErrTooManyCommands,
+	"TOO_MANY_INSTANCES": ErrTooManyInstances,
+	"LOCKED":             ErrLocked,
+	"HISTORY_DISABLED":   ErrHistoryDisabled,
+}
+
+// Error is an error returned by the agent. It is also a gRPC status error,
+// so status.Code and status.FromError work as usual.
+type Error struct {
+	// Err is the client error, like ErrNotFound, or nil if the error does not
+	// map to one. Errors from canceled calls are context.Canceled or
+	// context.DeadlineExceeded.
+	Err error
+
+	Code    codes.Code // gRPC status code
+	Message string     // gRPC status message
+
+	// Reason and Metadata are from the google.rpc.ErrorInfo detail, if any.
+	// Metadata has keys like "command" (name) and "id" (command ID).
+	Reason   string
+	Metadata map[string]string
+
+	// Violations are from the google.rpc.BadRequest detail, if any: invalid
+	// field, like "Arguments[0]" or "Parameters.name", to description.
+	Violations map[string]string
+
+	status *status.Status
+}
+
+func (e *Error) Error() string {
+	return e.status.Err().Error()
+}
+
+// Unwrap returns Err.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// GRPCStatus returns the gRPC status of the error.
+func (e *Error) GRPCStatus() *status.Status {
+	return e.status
+}
+
+// clientError maps a gRPC error to an *Error. Other errors are returned as is.
+func clientError(err error) error {
+	if err == nil {
+		return nil
+	}
+	s, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	e := &Error{
+		Code:    s.Code(),
+		Message: s.Message(),
+		status:  s,
+	}
+	for _, d := range s.Details() {
+		switch d := d.(type) {
+		case *errdetails.ErrorInfo:
+			if d.Domain == errorDomain {
+				e.Reason = d.Reason
+				e.Metadata = d.Metadata
+			}
+		case *errdetails.BadRequest:
+			e.Violations = map[string]string{}
+			for _, v := range d.FieldViolations {
+				e.Violations[v.Field] = v.Description
+			}
+		}
+	}
+
+	// Agents without error details return only codes and messages
+	e.Err = reasonErrors[e.Reason]
+	if e.Err == nil {
+		switch e.Code {
+		case codes.InvalidArgument:
+			if strings.HasPrefix(e.Message, "unknown command") {
+				e.Err = ErrUnknownCommand
+			} else {
+				e.Err = ErrInvalidArgs
+			}
+		case codes.NotFound:
+			e.Err = ErrNotFound
+		case codes.PermissionDenied:
+			e.Err = ErrPermissionDenied
+		case codes.Unavailable:
+			e.Err = ErrAgentUnavailable
+		case codes.Canceled:
+			e.Err = context.Canceled
+		case codes.DeadlineExceeded:
+			e.Err = context.DeadlineExceeded
+		}
+	}
+	return e
+}
+
+// statusError returns a gRPC status error with a google.rpc.ErrorInfo detail
+// of the reason (see reasonErrors) and metadata, and optional other details.
+func statusError(code codes.Code, reason string, metadata map[string]string, msg string, details ...proto.Message) error {
+	s := status.New(code, msg)
+	details = append([]proto.Message{&errdetails.ErrorInfo{
+		Reason:   reason,
+		Domain:   errorDomain,
+		Metadata: metadata,
+	}}, details...)
+	if sd, err := s.WithDetails(details...); err == nil {
+		s = sd
+	}
+	return s.Err()
+}
+
+// badRequest returns a google.rpc.BadRequest detail with one field violation.
+func badRequest(field string, err error) *errdetails.BadRequest {
+	return &errdetails.BadRequest{
+		FieldViolations: []*errdetails.BadRequest_FieldViolation{
+			{Field: field, Description: err.Error()},
+		},
+	}
+}
+
+// unavailable returns an error from Open that wraps ErrAgentUnavailable and err.
+func unavailable(addr string, err error) error {
+	return fmt.Errorf("%w: %s: %w", ErrAgentUnavailable, addr, err)
+}
@@ -22,7 +22,6 @@ import (
 	"log"
 	"net"
 	"os"
-	"time"
 
 	"github.com/square/rce-agent"
 	"github.com/square/rce-agent/pb"
@@ -34,6 +33,7 @@ var (
 	flagTLSCA      string
 	flagServerAddr string
 	flagTimeout    uint
+	flagList       bool
 )
 
 func init() {
@@ -42,6 +42,7 @@ func init() {
 	flag.StringVar(&flagTLSCA, "tls-ca", "", "TLS certificate authority")
 	flag.StringVar(&flagServerAddr, "server-addr", "127.0.0.1:5501", "Server address:port")
 	flag.UintVar(&flagTimeout, "timeout", 3000, "Dial timeout (milliseconds)")
+	flag.BoolVar(&flagList, "list", false, "List commands that the server allows")
 }
 
 func main() {
@@ -50,12 +51,12 @@ func main() {
 	// ----------------------------------------------------------------------
 	flag.Parse()
 	args := flag.Args()
-	if len(args) < 1 {
+	if len(args) < 1 && !flagList {
 		fmt.Println("Usage: client [options] command [args...]")
+		fmt.Println("       client [options] -list")
 		fmt.Println("\"command\" is a command name from the server whitelist")
 		os.Exit(1)
 	}
-	cmd := args[0] // remote whitelist command
 
 	// ----------------------------------------------------------------------
 	// Load TLS if given
@@ -96,6 +97,22 @@ func main() {
 	defer client.Close() // *** Remember to close the client connection! ***
 	log.Printf("Connected")
 
+	// ----------------------------------------------------------------------
+	// List remote commands
+	// ----------------------------------------------------------------------
+	// The agent returns only the commands that this client is allowed to run.
+	if flagList {
+		specs, err := client.Commands()
+		if err != nil {
+			log.Fatalf("client.Commands: %s", err)
+		}
+		for _, spec := range specs {
+			fmt.Printf("%-12s %s\n", spec.Name, spec.Description)
+		}
+		return
+	}
+	cmd := args[0] // remote whitelist command
+
 	// ----------------------------------------------------------------------
 	// Start remote command
 	// ----------------------------------------------------------------------
@@ -114,56 +131,28 @@ func main() {
 	// ----------------------------------------------------------------------
 	// In the simplest case, we could call client.Wait(id) (below) and block
 	// until the command finishes. But for this example we do something more
-	// realistic: we presume the command might take a little while, so we call
-	// client.GetStatus(id) every 2 seconds. If the command takes <2s, then
-	// this loop does nothing. But if the command takes >2s, then the loop
-	// streams the STDOUT and STDERR of the command.
-
-	// First, wait for command to finish in a goroutine so we we don't block.
-	// When the command finishes, close doneChan to signal the other goroutine
-	// (below) to stop, too, and unblock this main func below.
-	doneChan := make(chan struct{})
-	var finalStatus *pb.Status
-	var finalErr error
-	go func() {
-		finalStatus, finalErr = client.Wait(id) // block waiting for command to finish
-		close(doneChan)                         // stop goroutine below and unblock main
-	}()
-
-	// Second, every 2s get the command status which includes its STDOUT and
-	// STDERR. The outputs are cumulative, so we have to track the line number
-	// for each and print the tail. So if the final output would be,
-	//   1
-	//   2
-	//   3
-	// Then Stdout will be like []{"1"}, []{"1", "2"}, []{"1", "2", "3"}, if
-	// we check it three times.
+	// realistic: we presume the command might take a little while, so we first
+	// call client.Stream(id, ...) which prints each line of STDOUT and STDERR
+	// as the remote command produces it.
 	//
-	// This goroutine stops once the Wait goroutine above stops. They're
-	// synchronized on doneChan.
-	go func() {
-		ticker := time.NewTicker(2 * time.Second)
-		stdoutLine := 0
-		stderrLine := 0
-		for {
-			select {
-			case <-doneChan:
-				return
-			case <-ticker.C:
-				status, err := client.GetStatus(id)
-				if err != nil {
-					log.Printf("client.GetStatus: %s", err)
-				}
-				printOutput(status.Stdout, stdoutLine)
-				stdoutLine = len(status.Stdout)
-				printOutput(status.Stderr, stderrLine)
-				stderrLine = len(status.Stderr)
-			}
+	// The agent sends output produced before the call first, then each new
+	// line as it's produced, so no output is missed or repeated. Stream returns
+	// when the command is done and all its output has been sent. Stream does
+	// not reap the command, so we call Wait after it to reap the command and
+	// get its final status. Do not call Wait first (or concurrently): once Wait
+	// reaps the command, Stream returns a "not found" error.
+	err = client.Stream(id, func(out *pb.Output) {
+		if out.Stream == pb.STREAM_STDERR {
+			fmt.Fprintln(os.Stderr, out.Line)
+		} else {
+			fmt.Println(out.Line)
 		}
-	}()
+	})
+	if err != nil {
+		log.Printf("client.Stream: %s", err)
+	}
 
-	// Wait for the first goroutine above to signal that the command has finished
-	<-doneChan
+	finalStatus, finalErr := client.Wait(id) // command is done, so this returns immediately
 
 	// ----------------------------------------------------------------------
 	// Command done
@@ -183,6 +172,7 @@ func main() {
 	fmt.Printf(lnfmt, "StopTime", finalStatus.StopTime)
 	fmt.Printf(lnfmt, "ExitCode", finalStatus.ExitCode)
 	fmt.Printf(lnfmt, "Error", finalStatus.Error)
+	fmt.Printf(lnfmt, "Signal", finalStatus.Signal)
 	fmt.Printf(lnfmt, "Stdout", "")
 	for _, line := range finalStatus.Stdout {
 		fmt.Printf(lnfmt, "", line)
@@ -192,12 +182,3 @@ func main() {
 		fmt.Printf(lnfmt, "", line)
 	}
 }
-
-func printOutput(lines []string, fromLine int) {
-	if len(lines) < fromLine {
-		return
-	}
-	for i := fromLine; i < len(lines); i++ {
-		fmt.Println(lines[i])
-	}
-}
@@ -13,7 +13,7 @@
 	This example code and your agent code should be similar because there is not
 	much variation for running the server. One thing that will be different:
 	to make this agent long-running, we purposely block on channel recv and wait
-	for a CTRL-C signal to gracefully shutdown. Your agent might be in an API
+	for a CTRL-C signal to gracefully shutdown (or SIGHUP to reload commands). Your agent might be in an API
 	or other back end system that's inherently long-running.
 */
 
@@ -38,6 +38,12 @@ var (
 	flagTLSCA        string
 	flagAddr         string
 	flagCommandsFile string
+	flagAuditLog     string
+	flagACLFile      string
+	flagMetricsAddr  string
+	flagReflection   bool
+	flagHistorySize  int
+	flagHistoryFile  string
 )
 
 func init() {
@@ -46,6 +52,12 @@ func init() {
 	flag.StringVar(&flagTLSCA, "tls-ca", "", "TLS certificate authority")
 	flag.StringVar(&flagAddr, "addr", "127.0.0.1:5501", "Address and port to listen on")
 	flag.StringVar(&flagCommandsFile, "commands", "commands.yaml", "Commands whilelist file")
+	flag.StringVar(&flagAuditLog, "audit-log", "", "Audit log file (JSON lines)")
+	flag.StringVar(&flagACLFile, "acl", "", "Client access control list file")
+	flag.StringVar(&flagMetricsAddr, "metrics-addr", "", "Address and port for Prometheus metrics (/metrics)")
+	flag.BoolVar(&flagReflection, "reflection", false, "Enable gRPC server reflection")
+	flag.IntVar(&flagHistorySize, "history-size", 0, "Number of done commands to keep in history (0 to disable)")
+	flag.StringVar(&flagHistoryFile, "history-file", "", "History file (JSON lines), requires -history-size")
 }
 
 func main() {
@@ -86,10 +98,42 @@ func main() {
 		log.Fatalf("Error loading commands whitelist file %s: %s\n", commandsFile, err)
 	}
 
+	// ----------------------------------------------------------------------
+	// Load client access control list if given
+	// ----------------------------------------------------------------------
+	var acl rce.ACL
+	if flagACLFile != "" {
+		acl, err = rce.LoadACL(flagACLFile)
+		if err != nil {
+			log.Fatalf("Error loading ACL file %s: %s\n", flagACLFile, err)
+		}
+	}
+
+	// ----------------------------------------------------------------------
+	// Open audit log if given
+	// ----------------------------------------------------------------------
+	var audit rce.AuditSink
+	if flagAuditLog != "" {
+		audit, err = rce.NewFileAuditSink(flagAuditLog)
+		if err != nil {
+			log.Fatalf("Error opening audit log %s: %s\n", flagAuditLog, err)
+		}
+	}
+
 	// ----------------------------------------------------------------------
 	// Create and start agent
 	// ----------------------------------------------------------------------
-	srv := rce.NewServer(flagAddr, tlsConfig, commands)
+	srv := rce.NewServerWithConfig(rce.ServerConfig{
+		Addr:            flagAddr,
+		TLS:             tlsConfig,
+		AllowedCommands: commands,
+		Audit:           audit,
+		ACL:             acl,
+		MetricsAddr:     flagMetricsAddr,
+		Reflection:      flagReflection,
+		HistorySize:     flagHistorySize,
+		HistoryFile:     flagHistoryFile,
+	})
 	if err := srv.StartServer(); err != nil {
 		log.Fatalf("Error starting server: %s\n", err)
 	}
@@ -100,12 +144,23 @@ func main() {
 	// need to keep this Go program running...
 
 	// ----------------------------------------------------------------------
-	// Wait for CTRL-C for graceful shutdown
+	// Wait for CTRL-C for graceful shutdown, reload commands on SIGHUP
 	// ----------------------------------------------------------------------
-	c := make(chan os.Signal)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-	fmt.Println("CTRL-C to shut down")
-	<-c
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	fmt.Println("CTRL-C to shut down, SIGHUP to reload commands")
+	for sig := range c {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		commands, err := cmd.LoadCommands(commandsFile)
+		if err == nil {
+			err = srv.Reload(commands)
+		}
+		if err != nil {
+			log.Printf("Error reloading commands whitelist file %s: %s\n", commandsFile, err)
+		}
+	}
 	fmt.Println("Shutting down...")
 	if err := srv.StopServer(); err != nil {
 		log.Printf("Error stopping server: %s\n", err)
@@ -0,0 +1,233 @@
+// Copyright 2017-2023 Block, Inc.
+
+package rce
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/square/rce-agent/pb"
+	context "golang.org/x/net/context"
+)
+
+// ErrRolloutStopped is returned by Fleet.Run if a canary agent failed or the
+// failure threshold was reached, so the command was not run on the remaining
+// agents.
+var ErrRolloutStopped = errors.New("rollout stopped")
+
+// Fleet runs a command on many agents. Agents are run in batches: first the
+// canary batch, if any, then batches of BatchSize agents. Each batch must
+// finish before the next starts. Within a batch, up to Parallel agents run at
+// once. The rollout stops if any canary agent fails, or if FailureThreshold is
+// reached, but commands already running are not stopped.
+type Fleet struct {
+	// Agents are the agent addresses ("host:port") to run the command on, in order.
+	Agents []string
+
+	// TLS and ClientOptions are used to make a Client for each agent.
+	TLS           *tls.Config
+	ClientOptions []ClientOption
+
+	// Parallel is how many agents run the command at once. The default
+	// (zero) is all agents in a batch.
+	Parallel int
+
+	// Canary is how many agents, from the start of Agents, run the command
+	// first. If any canary agent fails, the rollout stops. The default (zero)
+	// is no canary batch.
+	Canary int
+
+	// BatchSize is how many agents run the command in each batch after the
+	// canary batch. The default (zero) is one batch of all remaining agents.
+	BatchSize int
+
+	// FailureThreshold is the percentage of failed agents, out of all agents,
+	// that stops the rollout. For example, 5 stops the rollout once 5% of
+	// agents have failed. The default (zero) never stops the rollout.
+	FailureThreshold float64
+
+	// Timeout is the time limit per agent to connect, run, and wait for the
+	// command. When exceeded, the command is stopped and the agent fails with
+	// context.DeadlineExceeded. The default (zero) is no timeout.
+	Timeout time.Duration
+}
+
+// FleetResult is the result of running a command on one agent.
+type FleetResult struct {
+	Agent   string     // agent address
+	Status  *pb.Status // final status, nil if the command did not start or finish
+	Err     error      // nil if the command completed, else see RemoteCmd.Wait
+	Skipped bool       // true if not run because the rollout stopped
+}
+
+// Failed returns true if the agent ran but the command did not complete
+// successfully.
+func (r FleetResult) Failed() bool {
+	return !r.Skipped && r.Err != nil
+}
+
+// FleetSummary counts the agent results of Fleet.Run.
+type FleetSummary struct {
+	Agents   int           // total number of agents
+	Complete int           // commands completed successfully
+	Failed   int           // commands failed, timed out, or could not run
+	Skipped  int           // agents skipped because the rollout stopped
+	Duration time.Duration // run time of all agents
+}
+
+func (s FleetSummary) String() string {
+	return fmt.Sprintf("%d agents: %d complete, %d failed, %d skipped in %s",
+		s.Agents, s.Complete, s.Failed, s.Skipped, s.Duration.Round(time.Millisecond))
+}
+
+// Run runs the command on all agents and returns one result per agent, in the
+// same order as Agents, and a summary. The error is ErrRolloutStopped if the
+// rollout stopped, the context error if ctx is done before all agents run,
+// else nil, even if some agents failed.
+func (f Fleet) Run(ctx context.Context, command *pb.Command) ([]FleetResult, FleetSummary, error) {
+	t0 := time.Now()
+	results := make([]FleetResult, len(f.Agents))
+	for i, agent := range f.Agents {
+		results[i] = FleetResult{Agent: agent, Skipped: true}
+	}
+
+	var mux sync.Mutex
+	failed := 0
+	stopped := false
+	stop := func() bool {
+		mux.Lock()
+		defer mux.Unlock()
+		if f.FailureThreshold > 0 && float64(failed)*100 >= f.FailureThreshold*float64(len(f.Agents)) {
+			stopped = true
+		}
+		return stopped || ctx.Err() != nil
+	}
+
+	for n, batch := range f.batches() {
+		parallel := f.Parallel
+		if parallel <= 0 || parallel > len(batch) {
+			parallel = len(batch)
+		}
+		sem := make(chan struct{}, parallel)
+		var wg sync.WaitGroup
+		for _, i := range batch {
+			sem <- struct{}{}
+			if stop() {
+				<-sem
+				break
+			}
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				r := f.run(ctx, f.Agents[i], command)
+				mux.Lock()
+				results[i] = r
+				if r.Failed() {
+					failed++
+				}
+				mux.Unlock()
+			}(i)
+		}
+		wg.Wait()
+		mux.Lock()
+		if n == 0 && f.Canary > 0 && failed > 0 {
+			stopped = true // canary failed
+		}
+		mux.Unlock()
+		if stop() {
+			break
+		}
+	}
+
+	summary := FleetSummary{
+		Agents:   len(f.Agents),
+		Duration: time.Since(t0),
+	}
+	for _, r := range results {
+		switch {
+		case r.Skipped:
+			summary.Skipped++
+		case r.Failed():
+			summary.Failed++
+		default:
+			summary.Complete++
+		}
+	}
+
+	if summary.Skipped > 0 {
+		if err := ctx.Err(); err != nil {
+			return results, summary, err
+		}
+		return results, summary, ErrRolloutStopped
+	}
+	return results, summary, nil
+}
+
+// batches returns the indexes of Agents in each batch.
+func (f Fleet) batches() [][]int {
+	var batches [][]int
+	i := 0
+	if f.Canary > 0 {
+		n := f.Canary
+		if n > len(f.Agents) {
+			n = len(f.Agents)
+		}
+		batches = append(batches, indexes(0, n))
+		i = n
+	}
+	size := f.BatchSize
+	if size <= 0 {
+		size = len(f.Agents)
+	}
+	for i < len(f.Agents) {
+		n := i + size
+		if n > len(f.Agents) {
+			n = len(f.Agents)
+		}
+		batches = append(batches, indexes(i, n))
+		i = n
+	}
+	return batches
+}
+
+func indexes(from, to int) []int {
+	idx := make([]int, 0, to-from)
+	for i := from; i < to; i++ {
+		idx = append(idx, i)
+	}
+	return idx
+}
+
+// run runs the command on one agent.
+func (f Fleet) run(ctx context.Context, agent string, command *pb.Command) FleetResult {
+	r := FleetResult{Agent: agent}
+	if f.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, f.Timeout)
+		defer cancel()
+	}
+
+	host, port, err := net.SplitHostPort(agent)
+	if err != nil {
+		r.Err = err
+		return r
+	}
+	c := NewClient(f.TLS, f.ClientOptions...)
+	if err := c.OpenContext(ctx, host, port); err != nil {
+		r.Err = err
+		return r
+	}
+	defer c.Close()
+
+	rc := CommandContext(ctx, c, command.Name, command.Arguments...)
+	rc.Parameters = command.Parameters
+	rc.Timeout = time.Duration(command.Timeout) * time.Second
+	r.Err = rc.Run()
+	r.Status = rc.Status
+	return r
+}
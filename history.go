@@ -0,0 +1,206 @@
+// Copyright 2017-2023 Block, Inc.
+
+package rce
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/square/rce-agent/cmd"
+	"github.com/square/rce-agent/pb"
+)
+
+// historyRecord is one done command in the history and history file.
+type historyRecord struct {
+	Status   *pb.Status `json:"status"`
+	Owner    string     `json:"owner,omitempty"`    // Identity.String of the client
+	Identity *Identity  `json:"identity,omitempty"` // for HistoryQuery.Client
+}
+
+// history is the bounded history of done commands. If file is set, records
+// are appended to it as JSON lines, and the file is rewritten with only the
+// kept records when loaded and when it has twice as many records as kept.
+type history struct {
+	*sync.Mutex
+	size     int             // max records, zero for no limit
+	age      time.Duration   // max age since command stopped, zero for no limit
+	file     string          // optional
+	records  []historyRecord // oldest first
+	f        *os.File        // file opened for appending
+	appended int             // records appended since file was rewritten
+}
+
+func newHistory(size int, age time.Duration, file string) *history {
+	return &history{
+		Mutex: &sync.Mutex{},
+		size:  size,
+		age:   age,
+		file:  file,
+	}
+}
+
+// load reads the history file, if it exists, then rewrites it with only the
+// kept records and opens it for appending.
+func (h *history) load() error {
+	if h.file == "" {
+		return nil
+	}
+	h.Lock()
+	defer h.Unlock()
+
+	f, err := os.Open(h.file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if f != nil {
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(nil, 64*1024*1024) // records include command output
+		for scanner.Scan() {
+			var r historyRecord
+			if err := json.Unmarshal(scanner.Bytes(), &r); err != nil || r.Status == nil {
+				log.Printf("ignoring invalid history record in %s: %s", h.file, err)
+				continue
+			}
+			h.records = append(h.records, r)
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	}
+	h.prune()
+	return h.write()
+}
+
+// write rewrites the history file with the kept records and reopens it for
+// appending. The caller must hold the lock.
+func (h *history) write() error {
+	tmp, err := os.CreateTemp(filepath.Dir(h.file), filepath.Base(h.file)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op after rename
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for _, r := range h.records {
+		if err := enc.Encode(r); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), h.file); err != nil {
+		return err
+	}
+
+	if h.f != nil {
+		h.f.Close()
+	}
+	h.f, err = os.OpenFile(h.file, os.O_WRONLY|os.O_APPEND, 0600)
+	h.appended = 0
+	return err
+}
+
+// record waits for the command to be done, then adds it to the history.
+func (h *history) record(rceCmd *cmd.Cmd, owner *Identity) {
+	<-rceCmd.Done()
+	h.add(historyRecord{
+		Status:   mapStatus(rceCmd),
+		Owner:    owner.String(),
+		Identity: owner,
+	})
+}
+
+func (h *history) add(r historyRecord) {
+	h.Lock()
+	defer h.Unlock()
+	h.records = append(h.records, r)
+	h.prune()
+	if h.f == nil {
+		return // no file, or closed
+	}
+	bytes, err := json.Marshal(r)
+	if err == nil {
+		_, err = h.f.Write(append(bytes, '\n'))
+	}
+	if err != nil {
+		log.Printf("cmd=%s: error writing history file %s: %s", r.Status.ID, h.file, err)
+		return
+	}
+	h.appended++
+	if h.appended >= len(h.records) {
+		if err := h.write(); err != nil {
+			log.Printf("error rewriting history file %s: %s", h.file, err)
+		}
+	}
+}
+
+// prune removes records over the size limit or older than the age limit.
+// The caller must hold the lock.
+func (h *history) prune() {
+	n := 0
+	if h.size > 0 && len(h.records) > h.size {
+		n = len(h.records) - h.size
+	}
+	if h.age > 0 {
+		oldest := time.Now().Add(-h.age).UnixNano()
+		for n < len(h.records) && h.records[n].Status.StopTime < oldest {
+			n++
+		}
+	}
+	if n > 0 {
+		h.records = append([]historyRecord(nil), h.records[n:]...)
+	}
+}
+
+// query returns records matching the query and for which keep returns true,
+// oldest first. The query limit is applied last. If keep is nil, it is not
+// called.
+func (h *history) query(q *pb.HistoryQuery, keep func(historyRecord) bool) []historyRecord {
+	h.Lock()
+	defer h.Unlock()
+	h.prune()
+	var records []historyRecord
+	for _, r := range h.records {
+		switch {
+		case q.Name != "" && r.Status.Name != q.Name:
+			continue
+		case q.State != pb.STATE_UNKNOWN && r.Status.State != q.State:
+			continue
+		case q.Since > 0 && r.Status.StopTime < q.Since:
+			continue
+		case q.Until > 0 && r.Status.StopTime >= q.Until:
+			continue
+		case q.Client != "" && !r.Identity.Matches(q.Client):
+			continue
+		case keep != nil && !keep(r):
+			continue
+		}
+		records = append(records, r)
+	}
+	if q.Limit > 0 && int64(len(records)) > q.Limit {
+		records = records[int64(len(records))-q.Limit:]
+	}
+	return records
+}
+
+// close closes the history file. Commands done after are kept in memory only.
+func (h *history) close() {
+	h.Lock()
+	defer h.Unlock()
+	if h.f != nil {
+		h.f.Close()
+		h.f = nil
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2017-2023 Block, Inc.
+
+package rce
+
+import (
+	"crypto/x509"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// Identity is a client identity from its verified TLS certificate.
+type Identity struct {
+	Subject    string   `json:"subject"`             // full subject DN
+	CommonName string   `json:"common_name"`         // subject CN
+	DNSNames   []string `json:"dns_names,omitempty"` // DNS SANs
+	URIs       []string `json:"uris,omitempty"`      // URI SANs, like SPIFFE IDs
+	IPs        []string `json:"ips,omitempty"`       // IP address SANs
+}
+
+// certIdentity returns the Identity of the certificate.
+func certIdentity(cert *x509.Certificate) *Identity {
+	id := &Identity{
+		Subject:    cert.Subject.String(),
+		CommonName: cert.Subject.CommonName,
+		DNSNames:   cert.DNSNames,
+	}
+	for _, uri := range cert.URIs {
+		id.URIs = append(id.URIs, uri.String())
+	}
+	for _, ip := range cert.IPAddresses {
+		id.IPs = append(id.IPs, ip.String())
+	}
+	return id
+}
+
+// peerIdentity returns the client address and, if the client connected with
+// TLS and its certificate was verified, its Identity. The Identity is nil for
+// insecure servers.
+func peerIdentity(ctx context.Context) (string, *Identity) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "", nil
+	}
+	var addr string
+	if p.Addr != nil {
+		addr = p.Addr.String()
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.VerifiedChains[0]) == 0 {
+		return addr, nil
+	}
+	return addr, certIdentity(tlsInfo.State.VerifiedChains[0][0])
+}
+
+// String returns the full identity: subject DN and all SANs. Two identities
+// are the same if their strings are equal. It returns an empty string if the
+// Identity is nil.
+func (id *Identity) String() string {
+	if id == nil {
+		return ""
+	}
+	parts := []string{id.Subject}
+	for _, name := range id.DNSNames {
+		parts = append(parts, "dns:"+name)
+	}
+	for _, uri := range id.URIs {
+		parts = append(parts, "uri:"+uri)
+	}
+	for _, ip := range id.IPs {
+		parts = append(parts, "ip:"+ip)
+	}
+	return strings.Join(parts, " ")
+}
+
+// Matches returns true if the client identity string matches the Identity.
+// A client identity string is one of:
+//
+//	cn:NAME        certificate subject common name
+//	dns:NAME       DNS SAN
+//	uri:URI        URI SAN
+//	spiffe://...   SPIFFE ID (URI SAN), same as "uri:spiffe://..."
+//
+// Matching is exact and case-sensitive. A nil Identity matches nothing.
+func (id *Identity) Matches(client string) bool {
+	if id == nil {
+		return false
+	}
+	kind, value, _ := strings.Cut(client, ":")
+	switch kind {
+	case "cn":
+		return value != "" && id.CommonName == value
+	case "dns":
+		return contains(id.DNSNames, value)
+	case "uri":
+		return contains(id.URIs, value)
+	case "spiffe":
+		return contains(id.URIs, client)
+	}
+	return false
+}
+
+// validClient returns true if the client identity string is valid. See Identity.Matches.
+func validClient(client string) bool {
+	kind, value, _ := strings.Cut(client, ":")
+	switch kind {
+	case "cn", "dns", "uri":
+		return value != ""
+	case "spiffe":
+		return strings.HasPrefix(value, "//") && len(value) > 2
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,273 @@
+// Copyright 2017-2023 Block, Inc.
+
+package rce
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/square/rce-agent/cmd"
+	"github.com/square/rce-agent/pb"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+// durationBuckets are the upper bounds (seconds) of the command duration histogram.
+var durationBuckets = []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300, 600, 1800, 3600}
+
+// metrics are server counters and histograms exposed in the Prometheus text
+// format when ServerConfig.MetricsAddr is set.
+type metrics struct {
+	*sync.Mutex
+	rpcs          map[[2]string]uint64 // [method, code]
+	started       map[string]uint64    // [command]
+	finished      map[[2]string]uint64 // [command, state]
+	durations     map[string]*histogram
+	reapedCmds    map[string]uint64 // [command]
+	abandonedCmds map[string]uint64 // [command]
+	tlsFailures   uint64
+	repo          cmd.Repo   // for running and unreaped gauges
+	sched         *scheduler // for queued gauge
+}
+
+type histogram struct {
+	counts []uint64 // per durationBuckets, not cumulative
+	count  uint64
+	sum    float64
+}
+
+func newMetrics(repo cmd.Repo, sched *scheduler) *metrics {
+	return &metrics{
+		Mutex:         &sync.Mutex{},
+		rpcs:          map[[2]string]uint64{},
+		started:       map[string]uint64{},
+		finished:      map[[2]string]uint64{},
+		durations:     map[string]*histogram{},
+		reapedCmds:    map[string]uint64{},
+		abandonedCmds: map[string]uint64{},
+		repo:          repo,
+		sched:         sched,
+	}
+}
+
+func (m *metrics) rpc(fullMethod string, err error) {
+	m.Lock()
+	defer m.Unlock()
+	m.rpcs[[2]string{path.Base(fullMethod), status.Code(err).String()}]++
+}
+
+func (m *metrics) tlsFailure() {
+	m.Lock()
+	defer m.Unlock()
+	m.tlsFailures++
+}
+
+func (m *metrics) reaped(name string) {
+	m.Lock()
+	defer m.Unlock()
+	m.reapedCmds[name]++
+}
+
+func (m *metrics) abandoned(name string) {
+	m.Lock()
+	defer m.Unlock()
+	m.abandonedCmds[name]++
+}
+
+// command counts the command as started, then waits for it to finish and
+// counts its final state and duration.
+func (m *metrics) command(rceCmd *cmd.Cmd) {
+	m.Lock()
+	m.started[rceCmd.Name]++
+	m.Unlock()
+
+	<-rceCmd.Done()
+	pbStatus := mapStatus(rceCmd)
+
+	m.Lock()
+	defer m.Unlock()
+	m.finished[[2]string{rceCmd.Name, pbStatus.State.String()}]++
+	if pbStatus.StartTime == 0 {
+		return // never ran (stopped while queued)
+	}
+	h := m.durations[rceCmd.Name]
+	if h == nil {
+		h = &histogram{counts: make([]uint64, len(durationBuckets))}
+		m.durations[rceCmd.Name] = h
+	}
+	d := time.Duration(pbStatus.StopTime - pbStatus.StartTime).Seconds()
+	for i, le := range durationBuckets {
+		if d <= le {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += d
+}
+
+// unaryInterceptor counts unary RPCs by method and code.
+func (m *metrics) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	resp, err := handler(ctx, req)
+	m.rpc(info.FullMethod, err)
+	return resp, err
+}
+
+// streamInterceptor counts streaming RPCs by method and code.
+func (m *metrics) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	err := handler(srv, ss)
+	m.rpc(info.FullMethod, err)
+	return err
+}
+
+// ServeHTTP writes all metrics in the Prometheus text exposition format.
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.write(w)
+}
+
+func (m *metrics) write(w io.Writer) {
+	// Gauges from repo and scheduler, read before locking metrics
+	var running, unreaped int
+	var oldest float64
+	now := time.Now()
+	for _, id := range m.repo.All() {
+		rceCmd := m.repo.Get(id)
+		if rceCmd == nil {
+			continue // reaped
+		}
+		pbStatus := mapStatus(rceCmd)
+		switch {
+		case pbStatus.State == pb.STATE_RUNNING:
+			running++
+		case pbStatus.StopTime > 0:
+			unreaped++
+			if age := now.Sub(time.Unix(0, pbStatus.StopTime)).Seconds(); age > oldest {
+				oldest = age
+			}
+		}
+	}
+	queued := m.sched.queued()
+
+	m.Lock()
+	defer m.Unlock()
+
+	header(w, "rce_rpcs_total", "counter", "RPC calls by method and gRPC status code.")
+	for _, k := range sortedKeys2(m.rpcs) {
+		fmt.Fprintf(w, "rce_rpcs_total{method=%s,code=%s} %d\n", label(k[0]), label(k[1]), m.rpcs[k])
+	}
+
+	header(w, "rce_commands_started_total", "counter", "Commands started (or queued) by command name.")
+	for _, name := range sortedKeys(m.started) {
+		fmt.Fprintf(w, "rce_commands_started_total{command=%s} %d\n", label(name), m.started[name])
+	}
+
+	header(w, "rce_commands_finished_total", "counter", "Commands finished by command name and final state: COMPLETE, FAIL, STOPPED, or TIMEOUT.")
+	for _, k := range sortedKeys2(m.finished) {
+		fmt.Fprintf(w, "rce_commands_finished_total{command=%s,state=%s} %d\n", label(k[0]), label(k[1]), m.finished[k])
+	}
+
+	header(w, "rce_command_duration_seconds", "histogram", "Command run time by command name.")
+	names := make([]string, 0, len(m.durations))
+	for name := range m.durations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		h := m.durations[name]
+		var n uint64
+		for i, le := range durationBuckets {
+			n += h.counts[i]
+			fmt.Fprintf(w, "rce_command_duration_seconds_bucket{command=%s,le=\"%g\"} %d\n", label(name), le, n)
+		}
+		fmt.Fprintf(w, "rce_command_duration_seconds_bucket{command=%s,le=\"+Inf\"} %d\n", label(name), h.count)
+		fmt.Fprintf(w, "rce_command_duration_seconds_sum{command=%s} %g\n", label(name), h.sum)
+		fmt.Fprintf(w, "rce_command_duration_seconds_count{command=%s} %d\n", label(name), h.count)
+	}
+
+	header(w, "rce_commands_reaped_total", "counter", "Done commands reaped by the server after ReapAfter, by command name.")
+	for _, name := range sortedKeys(m.reapedCmds) {
+		fmt.Fprintf(w, "rce_commands_reaped_total{command=%s} %d\n", label(name), m.reapedCmds[name])
+	}
+
+	header(w, "rce_commands_abandoned_total", "counter", "Commands stopped by the server after MaxUnobserved, by command name.")
+	for _, name := range sortedKeys(m.abandonedCmds) {
+		fmt.Fprintf(w, "rce_commands_abandoned_total{command=%s} %d\n", label(name), m.abandonedCmds[name])
+	}
+
+	header(w, "rce_commands_running", "gauge", "Commands running now.")
+	fmt.Fprintf(w, "rce_commands_running %d\n", running)
+
+	header(w, "rce_commands_queued", "gauge", "Commands queued (pending) now.")
+	fmt.Fprintf(w, "rce_commands_queued %d\n", queued)
+
+	header(w, "rce_commands_unreaped", "gauge", "Finished commands not yet reaped by Wait.")
+	fmt.Fprintf(w, "rce_commands_unreaped %d\n", unreaped)
+
+	header(w, "rce_commands_unreaped_oldest_seconds", "gauge", "Time since the oldest unreaped command finished.")
+	fmt.Fprintf(w, "rce_commands_unreaped_oldest_seconds %g\n", oldest)
+
+	header(w, "rce_tls_handshake_failures_total", "counter", "Client connections that failed the TLS handshake.")
+	fmt.Fprintf(w, "rce_tls_handshake_failures_total %d\n", m.tlsFailures)
+}
+
+func header(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// label returns the quoted and escaped label value.
+func label(v string) string {
+	return `"` + labelEscaper.Replace(v) + `"`
+}
+
+func sortedKeys(m map[string]uint64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedKeys2(m map[[2]string]uint64) [][2]string {
+	keys := make([][2]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	return keys
+}
+
+// tlsMetricsCreds counts failed TLS handshakes.
+type tlsMetricsCreds struct {
+	credentials.TransportCredentials
+	m *metrics
+}
+
+func (c tlsMetricsCreds) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	conn, authInfo, err := c.TransportCredentials.ServerHandshake(conn)
+	if err != nil {
+		c.m.tlsFailure()
+	}
+	return conn, authInfo, err
+}
+
+func (c tlsMetricsCreds) Clone() credentials.TransportCredentials {
+	return tlsMetricsCreds{TransportCredentials: c.TransportCredentials.Clone(), m: c.m}
+}
@@ -11,8 +11,13 @@ It is generated from these files:
 It has these top-level messages:
 	Empty
 	Status
+	Output
 	ID
 	Command
+	CommandSpec
+	Parameter
+	HistoryQuery
+	HistoryRecord
 */
 package pb
 
@@ -72,6 +77,27 @@ func (x STATE) String() string {
 }
 func (STATE) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{0} }
 
+type STREAM int32
+
+const (
+	STREAM_STDOUT STREAM = 0
+	STREAM_STDERR STREAM = 1
+)
+
+var STREAM_name = map[int32]string{
+	0: "STDOUT",
+	1: "STDERR",
+}
+var STREAM_value = map[string]int32{
+	"STDOUT": 0,
+	"STDERR": 1,
+}
+
+func (x STREAM) String() string {
+	return proto.EnumName(STREAM_name, int32(x))
+}
+func (STREAM) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
+
 type Empty struct {
 }
 
@@ -92,6 +118,7 @@ type Status struct {
 	Stdout    []string `protobuf:"bytes,9,rep,name=Stdout" json:"Stdout,omitempty"`
 	Stderr    []string `protobuf:"bytes,10,rep,name=Stderr" json:"Stderr,omitempty"`
 	Error     string   `protobuf:"bytes,11,opt,name=Error" json:"Error,omitempty"`
+	Signal    string   `protobuf:"bytes,12,opt,name=Signal" json:"Signal,omitempty"`
 }
 
 func (m *Status) Reset()                    { *m = Status{} }
@@ -176,6 +203,37 @@ func (m *Status) GetError() string {
 	return ""
 }
 
+func (m *Status) GetSignal() string {
+	if m != nil {
+		return m.Signal
+	}
+	return ""
+}
+
+type Output struct {
+	Stream STREAM `protobuf:"varint,1,opt,name=Stream,enum=rce.STREAM" json:"Stream,omitempty"`
+	Line   string `protobuf:"bytes,2,opt,name=Line" json:"Line,omitempty"`
+}
+
+func (m *Output) Reset()                    { *m = Output{} }
+func (m *Output) String() string            { return proto.CompactTextString(m) }
+func (*Output) ProtoMessage()               {}
+func (*Output) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
+
+func (m *Output) GetStream() STREAM {
+	if m != nil {
+		return m.Stream
+	}
+	return STREAM_STDOUT
+}
+
+func (m *Output) GetLine() string {
+	if m != nil {
+		return m.Line
+	}
+	return ""
+}
+
 type ID struct {
 	ID string `protobuf:"bytes,1,opt,name=ID" json:"ID,omitempty"`
 }
@@ -183,7 +241,7 @@ type ID struct {
 func (m *ID) Reset()                    { *m = ID{} }
 func (m *ID) String() string            { return proto.CompactTextString(m) }
 func (*ID) ProtoMessage()               {}
-func (*ID) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
+func (*ID) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
 
 func (m *ID) GetID() string {
 	if m != nil {
@@ -195,12 +253,19 @@ func (m *ID) GetID() string {
 type Command struct {
 	Name      string   `protobuf:"bytes,1,opt,name=Name" json:"Name,omitempty"`
 	Arguments []string `protobuf:"bytes,2,rep,name=Arguments" json:"Arguments,omitempty"`
+	// Optional timeout in seconds. If the command has a timeout, the lesser
+	// of the two is used. When exceeded, the command is stopped and its
+	// final state is TIMEOUT.
+	Timeout int64 `protobuf:"varint,3,opt,name=Timeout" json:"Timeout,omitempty"`
+	// Optional named parameters of the command, which replace placeholders
+	// in the command exec line on the agent.
+	Parameters map[string]string `protobuf:"bytes,4,rep,name=Parameters" json:"Parameters,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
 }
 
 func (m *Command) Reset()                    { *m = Command{} }
 func (m *Command) String() string            { return proto.CompactTextString(m) }
 func (*Command) ProtoMessage()               {}
-func (*Command) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
+func (*Command) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
 
 func (m *Command) GetName() string {
 	if m != nil {
@@ -216,12 +281,256 @@ func (m *Command) GetArguments() []string {
 	return nil
 }
 
+func (m *Command) GetTimeout() int64 {
+	if m != nil {
+		return m.Timeout
+	}
+	return 0
+}
+
+func (m *Command) GetParameters() map[string]string {
+	if m != nil {
+		return m.Parameters
+	}
+	return nil
+}
+
+type CommandSpec struct {
+	Name        string       `protobuf:"bytes,1,opt,name=Name" json:"Name,omitempty"`
+	Timeout     int64        `protobuf:"varint,2,opt,name=Timeout" json:"Timeout,omitempty"`
+	Tags        []string     `protobuf:"bytes,3,rep,name=Tags" json:"Tags,omitempty"`
+	Params      []*Parameter `protobuf:"bytes,4,rep,name=Params" json:"Params,omitempty"`
+	Description string       `protobuf:"bytes,5,opt,name=Description" json:"Description,omitempty"`
+	Usage       string       `protobuf:"bytes,6,opt,name=Usage" json:"Usage,omitempty"`
+	Owner       string       `protobuf:"bytes,7,opt,name=Owner" json:"Owner,omitempty"`
+}
+
+func (m *CommandSpec) Reset()                    { *m = CommandSpec{} }
+func (m *CommandSpec) String() string            { return proto.CompactTextString(m) }
+func (*CommandSpec) ProtoMessage()               {}
+func (*CommandSpec) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
+
+func (m *CommandSpec) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *CommandSpec) GetTimeout() int64 {
+	if m != nil {
+		return m.Timeout
+	}
+	return 0
+}
+
+func (m *CommandSpec) GetTags() []string {
+	if m != nil {
+		return m.Tags
+	}
+	return nil
+}
+
+func (m *CommandSpec) GetParams() []*Parameter {
+	if m != nil {
+		return m.Params
+	}
+	return nil
+}
+
+func (m *CommandSpec) GetDescription() string {
+	if m != nil {
+		return m.Description
+	}
+	return ""
+}
+
+func (m *CommandSpec) GetUsage() string {
+	if m != nil {
+		return m.Usage
+	}
+	return ""
+}
+
+func (m *CommandSpec) GetOwner() string {
+	if m != nil {
+		return m.Owner
+	}
+	return ""
+}
+
+type Parameter struct {
+	Name     string   `protobuf:"bytes,1,opt,name=Name" json:"Name,omitempty"`
+	Type     string   `protobuf:"bytes,2,opt,name=Type" json:"Type,omitempty"`
+	Required bool     `protobuf:"varint,3,opt,name=Required" json:"Required,omitempty"`
+	Default  string   `protobuf:"bytes,4,opt,name=Default" json:"Default,omitempty"`
+	Pattern  string   `protobuf:"bytes,5,opt,name=Pattern" json:"Pattern,omitempty"`
+	Values   []string `protobuf:"bytes,6,rep,name=Values" json:"Values,omitempty"`
+	Prefix   string   `protobuf:"bytes,7,opt,name=Prefix" json:"Prefix,omitempty"`
+	Min      string   `protobuf:"bytes,8,opt,name=Min" json:"Min,omitempty"`
+	Max      string   `protobuf:"bytes,9,opt,name=Max" json:"Max,omitempty"`
+}
+
+func (m *Parameter) Reset()                    { *m = Parameter{} }
+func (m *Parameter) String() string            { return proto.CompactTextString(m) }
+func (*Parameter) ProtoMessage()               {}
+func (*Parameter) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{6} }
+
+func (m *Parameter) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *Parameter) GetType() string {
+	if m != nil {
+		return m.Type
+	}
+	return ""
+}
+
+func (m *Parameter) GetRequired() bool {
+	if m != nil {
+		return m.Required
+	}
+	return false
+}
+
+func (m *Parameter) GetDefault() string {
+	if m != nil {
+		return m.Default
+	}
+	return ""
+}
+
+func (m *Parameter) GetPattern() string {
+	if m != nil {
+		return m.Pattern
+	}
+	return ""
+}
+
+func (m *Parameter) GetValues() []string {
+	if m != nil {
+		return m.Values
+	}
+	return nil
+}
+
+func (m *Parameter) GetPrefix() string {
+	if m != nil {
+		return m.Prefix
+	}
+	return ""
+}
+
+func (m *Parameter) GetMin() string {
+	if m != nil {
+		return m.Min
+	}
+	return ""
+}
+
+func (m *Parameter) GetMax() string {
+	if m != nil {
+		return m.Max
+	}
+	return ""
+}
+
+type HistoryQuery struct {
+	Name   string `protobuf:"bytes,1,opt,name=Name" json:"Name,omitempty"`
+	State  STATE  `protobuf:"varint,2,opt,name=State,enum=rce.STATE" json:"State,omitempty"`
+	Since  int64  `protobuf:"varint,3,opt,name=Since" json:"Since,omitempty"`
+	Until  int64  `protobuf:"varint,4,opt,name=Until" json:"Until,omitempty"`
+	Client string `protobuf:"bytes,5,opt,name=Client" json:"Client,omitempty"`
+	Limit  int64  `protobuf:"varint,6,opt,name=Limit" json:"Limit,omitempty"`
+}
+
+func (m *HistoryQuery) Reset()                    { *m = HistoryQuery{} }
+func (m *HistoryQuery) String() string            { return proto.CompactTextString(m) }
+func (*HistoryQuery) ProtoMessage()               {}
+func (*HistoryQuery) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{7} }
+
+func (m *HistoryQuery) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *HistoryQuery) GetState() STATE {
+	if m != nil {
+		return m.State
+	}
+	return STATE_UNKNOWN
+}
+
+func (m *HistoryQuery) GetSince() int64 {
+	if m != nil {
+		return m.Since
+	}
+	return 0
+}
+
+func (m *HistoryQuery) GetUntil() int64 {
+	if m != nil {
+		return m.Until
+	}
+	return 0
+}
+
+func (m *HistoryQuery) GetClient() string {
+	if m != nil {
+		return m.Client
+	}
+	return ""
+}
+
+func (m *HistoryQuery) GetLimit() int64 {
+	if m != nil {
+		return m.Limit
+	}
+	return 0
+}
+
+type HistoryRecord struct {
+	Status *Status `protobuf:"bytes,1,opt,name=Status" json:"Status,omitempty"`
+	Owner  string  `protobuf:"bytes,2,opt,name=Owner" json:"Owner,omitempty"`
+}
+
+func (m *HistoryRecord) Reset()                    { *m = HistoryRecord{} }
+func (m *HistoryRecord) String() string            { return proto.CompactTextString(m) }
+func (*HistoryRecord) ProtoMessage()               {}
+func (*HistoryRecord) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{8} }
+
+func (m *HistoryRecord) GetStatus() *Status {
+	if m != nil {
+		return m.Status
+	}
+	return nil
+}
+
+func (m *HistoryRecord) GetOwner() string {
+	if m != nil {
+		return m.Owner
+	}
+	return ""
+}
+
 func init() {
 	proto.RegisterType((*Empty)(nil), "rce.Empty")
 	proto.RegisterType((*Status)(nil), "rce.Status")
+	proto.RegisterType((*Output)(nil), "rce.Output")
 	proto.RegisterType((*ID)(nil), "rce.ID")
 	proto.RegisterType((*Command)(nil), "rce.Command")
+	proto.RegisterType((*CommandSpec)(nil), "rce.CommandSpec")
+	proto.RegisterType((*Parameter)(nil), "rce.Parameter")
+	proto.RegisterType((*HistoryQuery)(nil), "rce.HistoryQuery")
+	proto.RegisterType((*HistoryRecord)(nil), "rce.HistoryRecord")
 	proto.RegisterEnum("rce.STATE", STATE_name, STATE_value)
+	proto.RegisterEnum("rce.STREAM", STREAM_name, STREAM_value)
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -247,6 +556,18 @@ type RCEAgentClient interface {
 	Stop(ctx context.Context, in *ID, opts ...grpc.CallOption) (*Empty, error)
 	// Return a list of all running (not reaped) commands by ID.
 	Running(ctx context.Context, in *Empty, opts ...grpc.CallOption) (RCEAgent_RunningClient, error)
+	// Stream the output of a command line by line as it is produced. Output
+	// produced before the call is sent first. The stream ends when the command
+	// is done and all its output has been sent. This does not reap the command.
+	Stream(ctx context.Context, in *ID, opts ...grpc.CallOption) (RCEAgent_StreamClient, error)
+	// Return a list of allowed commands that the client can run.
+	ListCommands(ctx context.Context, in *Empty, opts ...grpc.CallOption) (RCEAgent_ListCommandsClient, error)
+	// Describe one allowed command by name. Only Command.Name is used.
+	DescribeCommand(ctx context.Context, in *Command, opts ...grpc.CallOption) (*CommandSpec, error)
+	// Return the final status of done commands kept in the agent history that
+	// match the query, oldest first. Commands are recorded when they are done,
+	// whether or not they have been reaped.
+	History(ctx context.Context, in *HistoryQuery, opts ...grpc.CallOption) (RCEAgent_HistoryClient, error)
 }
 
 type rCEAgentClient struct {
@@ -325,6 +646,111 @@ func (x *rCEAgentRunningClient) Recv() (*ID, error) {
 	return m, nil
 }
 
+func (c *rCEAgentClient) Stream(ctx context.Context, in *ID, opts ...grpc.CallOption) (RCEAgent_StreamClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_RCEAgent_serviceDesc.Streams[1], c.cc, "/rce.RCEAgent/Stream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &rCEAgentStreamClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type RCEAgent_StreamClient interface {
+	Recv() (*Output, error)
+	grpc.ClientStream
+}
+
+type rCEAgentStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *rCEAgentStreamClient) Recv() (*Output, error) {
+	m := new(Output)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *rCEAgentClient) ListCommands(ctx context.Context, in *Empty, opts ...grpc.CallOption) (RCEAgent_ListCommandsClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_RCEAgent_serviceDesc.Streams[2], c.cc, "/rce.RCEAgent/ListCommands", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &rCEAgentListCommandsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type RCEAgent_ListCommandsClient interface {
+	Recv() (*CommandSpec, error)
+	grpc.ClientStream
+}
+
+type rCEAgentListCommandsClient struct {
+	grpc.ClientStream
+}
+
+func (x *rCEAgentListCommandsClient) Recv() (*CommandSpec, error) {
+	m := new(CommandSpec)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *rCEAgentClient) DescribeCommand(ctx context.Context, in *Command, opts ...grpc.CallOption) (*CommandSpec, error) {
+	out := new(CommandSpec)
+	err := grpc.Invoke(ctx, "/rce.RCEAgent/DescribeCommand", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *rCEAgentClient) History(ctx context.Context, in *HistoryQuery, opts ...grpc.CallOption) (RCEAgent_HistoryClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_RCEAgent_serviceDesc.Streams[3], c.cc, "/rce.RCEAgent/History", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &rCEAgentHistoryClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type RCEAgent_HistoryClient interface {
+	Recv() (*HistoryRecord, error)
+	grpc.ClientStream
+}
+
+type rCEAgentHistoryClient struct {
+	grpc.ClientStream
+}
+
+func (x *rCEAgentHistoryClient) Recv() (*HistoryRecord, error) {
+	m := new(HistoryRecord)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // Server API for RCEAgent service
 
 type RCEAgentServer interface {
@@ -340,6 +766,18 @@ type RCEAgentServer interface {
 	Stop(context.Context, *ID) (*Empty, error)
 	// Return a list of all running (not reaped) commands by ID.
 	Running(*Empty, RCEAgent_RunningServer) error
+	// Stream the output of a command line by line as it is produced. Output
+	// produced before the call is sent first. The stream ends when the command
+	// is done and all its output has been sent. This does not reap the command.
+	Stream(*ID, RCEAgent_StreamServer) error
+	// Return a list of allowed commands that the client can run.
+	ListCommands(*Empty, RCEAgent_ListCommandsServer) error
+	// Describe one allowed command by name. Only Command.Name is used.
+	DescribeCommand(context.Context, *Command) (*CommandSpec, error)
+	// Return the final status of done commands kept in the agent history that
+	// match the query, oldest first. Commands are recorded when they are done,
+	// whether or not they have been reaped.
+	History(*HistoryQuery, RCEAgent_HistoryServer) error
 }
 
 func RegisterRCEAgentServer(s *grpc.Server, srv RCEAgentServer) {
@@ -439,6 +877,87 @@ func (x *rCEAgentRunningServer) Send(m *ID) error {
 	return x.ServerStream.SendMsg(m)
 }
 
+func _RCEAgent_Stream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ID)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(RCEAgentServer).Stream(m, &rCEAgentStreamServer{stream})
+}
+
+type RCEAgent_StreamServer interface {
+	Send(*Output) error
+	grpc.ServerStream
+}
+
+type rCEAgentStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *rCEAgentStreamServer) Send(m *Output) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _RCEAgent_ListCommands_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(Empty)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(RCEAgentServer).ListCommands(m, &rCEAgentListCommandsServer{stream})
+}
+
+type RCEAgent_ListCommandsServer interface {
+	Send(*CommandSpec) error
+	grpc.ServerStream
+}
+
+type rCEAgentListCommandsServer struct {
+	grpc.ServerStream
+}
+
+func (x *rCEAgentListCommandsServer) Send(m *CommandSpec) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _RCEAgent_DescribeCommand_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Command)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RCEAgentServer).DescribeCommand(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rce.RCEAgent/DescribeCommand",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RCEAgentServer).DescribeCommand(ctx, req.(*Command))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RCEAgent_History_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(HistoryQuery)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(RCEAgentServer).History(m, &rCEAgentHistoryServer{stream})
+}
+
+type RCEAgent_HistoryServer interface {
+	Send(*HistoryRecord) error
+	grpc.ServerStream
+}
+
+type rCEAgentHistoryServer struct {
+	grpc.ServerStream
+}
+
+func (x *rCEAgentHistoryServer) Send(m *HistoryRecord) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 var _RCEAgent_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "rce.RCEAgent",
 	HandlerType: (*RCEAgentServer)(nil),
@@ -459,6 +978,10 @@ var _RCEAgent_serviceDesc = grpc.ServiceDesc{
 			MethodName: "Stop",
 			Handler:    _RCEAgent_Stop_Handler,
 		},
+		{
+			MethodName: "DescribeCommand",
+			Handler:    _RCEAgent_DescribeCommand_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -466,6 +989,21 @@ var _RCEAgent_serviceDesc = grpc.ServiceDesc{
 			Handler:       _RCEAgent_Running_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "Stream",
+			Handler:       _RCEAgent_Stream_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ListCommands",
+			Handler:       _RCEAgent_ListCommands_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "History",
+			Handler:       _RCEAgent_History_Handler,
+			ServerStreams: true,
+		},
 	},
 	Metadata: "rce.proto",
 }
@@ -473,32 +1011,60 @@ var _RCEAgent_serviceDesc = grpc.ServiceDesc{
 func init() { proto.RegisterFile("rce.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 422 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0x74, 0x92, 0xcf, 0x6a, 0xdb, 0x40,
-	0x10, 0xc6, 0xbd, 0xfa, 0x63, 0x49, 0xe3, 0x10, 0xc4, 0x10, 0xca, 0x62, 0xd2, 0x22, 0xd4, 0x8b,
-	0xe9, 0x21, 0x94, 0xf4, 0xd8, 0x93, 0xb0, 0xb6, 0x41, 0x34, 0x59, 0x0b, 0x49, 0x26, 0xd7, 0xaa,
-	0xf1, 0x62, 0x74, 0x90, 0x64, 0xd6, 0x2b, 0x68, 0xdf, 0xa2, 0x0f, 0xd3, 0x07, 0x2c, 0xbb, 0x52,
-	0x6c, 0x53, 0xc8, 0x6d, 0x7e, 0xdf, 0x37, 0x48, 0xb3, 0x33, 0x1f, 0x04, 0xf2, 0x45, 0xdc, 0x1d,
-	0x64, 0xaf, 0x7a, 0xb4, 0xe5, 0x8b, 0x88, 0x3d, 0x70, 0x59, 0x7b, 0x50, 0xbf, 0xe3, 0x3f, 0x16,
-	0xcc, 0x4b, 0x55, 0xab, 0xe1, 0x88, 0xd7, 0x60, 0x65, 0x29, 0x25, 0x11, 0x59, 0x05, 0x85, 0x95,
-	0xa5, 0x88, 0xe0, 0xf0, 0xba, 0x15, 0xd4, 0x32, 0x8a, 0xa9, 0x31, 0x02, 0x57, 0x77, 0x0b, 0x6a,
-	0x47, 0x64, 0x75, 0x7d, 0x0f, 0x77, 0xfa, 0xbb, 0x65, 0x95, 0x54, 0xac, 0x18, 0x0d, 0x0c, 0xc1,
-	0xce, 0xb3, 0x94, 0x3a, 0x11, 0x59, 0xd9, 0x85, 0x2e, 0xf1, 0x16, 0x82, 0x52, 0xd5, 0x52, 0x55,
-	0x4d, 0x2b, 0xa8, 0x6b, 0xf4, 0xb3, 0x80, 0x4b, 0xf0, 0x4b, 0xd5, 0x1f, 0x8c, 0x39, 0x37, 0xe6,
-	0x89, 0xb5, 0xc7, 0x7e, 0x35, 0x6a, 0xdd, 0xef, 0x04, 0xf5, 0x46, 0xef, 0x95, 0xf5, 0x74, 0x89,
-	0xdc, 0x1f, 0xa9, 0x1f, 0xd9, 0x7a, 0x3a, 0x5d, 0xe3, 0x3b, 0xfd, 0x96, 0x5d, 0x3f, 0x28, 0x1a,
-	0x18, 0x75, 0xa2, 0x49, 0x17, 0x52, 0x52, 0x38, 0xe9, 0x42, 0x4a, 0xbc, 0x01, 0x97, 0x49, 0xd9,
-	0x4b, 0xba, 0x30, 0x4f, 0x1c, 0x21, 0xbe, 0xd1, 0x7b, 0xf8, 0x7f, 0x1b, 0xf1, 0x57, 0xf0, 0xd6,
-	0x7d, 0xdb, 0xd6, 0xdd, 0xee, 0xb4, 0x18, 0x72, 0xb1, 0x98, 0x5b, 0x08, 0x12, 0xb9, 0x1f, 0x5a,
-	0xd1, 0xa9, 0x23, 0xb5, 0xcc, 0x5f, 0xce, 0xc2, 0xa7, 0x1f, 0xe0, 0x9a, 0x25, 0xe1, 0x02, 0xbc,
-	0x2d, 0xff, 0xce, 0x37, 0xcf, 0x3c, 0x9c, 0x69, 0xc8, 0x19, 0x4f, 0x33, 0xfe, 0x10, 0x12, 0x0d,
-	0xc5, 0x96, 0x73, 0x0d, 0x16, 0x5e, 0x81, 0xbf, 0xde, 0x3c, 0xe5, 0x8f, 0xac, 0x62, 0xa1, 0x8d,
-	0x3e, 0x38, 0xdf, 0x92, 0xec, 0x31, 0x74, 0x74, 0x53, 0x95, 0x3d, 0xb1, 0xcd, 0xb6, 0x0a, 0x5d,
-	0x0d, 0x65, 0xb5, 0xc9, 0x73, 0x96, 0x86, 0xf3, 0xfb, 0xbf, 0x04, 0xfc, 0x62, 0xcd, 0x92, 0xbd,
-	0xe8, 0xd4, 0x74, 0x25, 0xa9, 0xf0, 0xca, 0xdc, 0x67, 0x9a, 0x7b, 0xe9, 0x19, 0xca, 0xd2, 0x78,
-	0x86, 0x1f, 0xc0, 0x79, 0xae, 0x1b, 0x85, 0xaf, 0xd2, 0x72, 0x31, 0x5e, 0xd2, 0x24, 0x21, 0x9e,
-	0xe1, 0x47, 0x08, 0x1e, 0x84, 0x9a, 0x82, 0xf1, 0x56, 0xd3, 0x7b, 0x70, 0xf4, 0xa9, 0xce, 0xfe,
-	0x18, 0x87, 0x31, 0x58, 0x33, 0x8c, 0xc1, 0x2b, 0x86, 0xae, 0x6b, 0xba, 0x3d, 0x5e, 0x18, 0x17,
-	0x53, 0x7c, 0x26, 0x3f, 0xe7, 0x26, 0x93, 0x5f, 0xfe, 0x05, 0x00, 0x00, 0xff, 0xff, 0x10, 0x9a,
-	0x29, 0x00, 0xa0, 0x02, 0x00, 0x00,
+	// 869 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x74, 0x55, 0xcd, 0x8e, 0xe3, 0x44,
+	0x10, 0x8e, 0x9d, 0x5f, 0x57, 0xc2, 0xac, 0x69, 0x8d, 0x50, 0x2b, 0x1a, 0x50, 0xe4, 0x91, 0xd0,
+	0x68, 0x0f, 0xa3, 0xd5, 0x2c, 0x07, 0x84, 0xe0, 0x10, 0xc5, 0x66, 0x09, 0xe4, 0x8f, 0x4e, 0x86,
+	0xbd, 0xe2, 0x4d, 0x7a, 0xa3, 0x16, 0xb1, 0x1d, 0xda, 0x6d, 0x98, 0xbc, 0x0c, 0x67, 0x5e, 0x84,
+	0x17, 0xe0, 0xc2, 0x93, 0x70, 0x5f, 0x55, 0x77, 0xdb, 0xf1, 0x8e, 0x66, 0x6e, 0xf5, 0x7d, 0xd5,
+	0x71, 0x7f, 0x5f, 0x55, 0x75, 0x05, 0x3c, 0xb9, 0xe5, 0xb7, 0x47, 0x99, 0xa9, 0x8c, 0x34, 0xe5,
+	0x96, 0x07, 0x5d, 0x68, 0x47, 0xc9, 0x51, 0x9d, 0x82, 0xbf, 0x5d, 0xe8, 0xac, 0x55, 0xac, 0x8a,
+	0x9c, 0x5c, 0x80, 0x3b, 0x0d, 0xa9, 0x33, 0x72, 0x6e, 0x3c, 0xe6, 0x4e, 0x43, 0x42, 0xa0, 0xb5,
+	0x88, 0x13, 0x4e, 0x5d, 0xcd, 0xe8, 0x98, 0x8c, 0xa0, 0x8d, 0xa7, 0x39, 0x6d, 0x8e, 0x9c, 0x9b,
+	0x8b, 0x3b, 0xb8, 0xc5, 0xef, 0xae, 0x37, 0xe3, 0x4d, 0xc4, 0x4c, 0x82, 0xf8, 0xd0, 0x5c, 0x4d,
+	0x43, 0xda, 0x1a, 0x39, 0x37, 0x4d, 0x86, 0x21, 0xb9, 0x02, 0x6f, 0xad, 0x62, 0xa9, 0x36, 0x22,
+	0xe1, 0xb4, 0xad, 0xf9, 0x33, 0x41, 0x86, 0xd0, 0x5b, 0xab, 0xec, 0xa8, 0x93, 0x1d, 0x9d, 0xac,
+	0x30, 0xe6, 0xa2, 0x07, 0xa1, 0x26, 0xd9, 0x8e, 0xd3, 0xae, 0xc9, 0x95, 0x18, 0xd5, 0x8d, 0xe5,
+	0x3e, 0xa7, 0xbd, 0x51, 0x13, 0xd5, 0x61, 0x4c, 0x3e, 0x43, 0x2f, 0xbb, 0xac, 0x50, 0xd4, 0xd3,
+	0xac, 0x45, 0x96, 0xe7, 0x52, 0x52, 0xa8, 0x78, 0x2e, 0x25, 0xb9, 0x84, 0x76, 0x24, 0x65, 0x26,
+	0x69, 0x5f, 0x5b, 0x34, 0x40, 0x9f, 0x16, 0xfb, 0x34, 0x3e, 0xd0, 0x81, 0xa6, 0x2d, 0x0a, 0xc6,
+	0xd0, 0x59, 0x16, 0xea, 0x58, 0x28, 0x72, 0x8d, 0xdf, 0x93, 0x3c, 0x4e, 0x74, 0xb5, 0x2e, 0xee,
+	0xfa, 0xb6, 0x0c, 0x2c, 0x1a, 0xcf, 0x99, 0x4d, 0xa1, 0xc0, 0x99, 0x48, 0xab, 0xf2, 0x61, 0x1c,
+	0x5c, 0x62, 0x89, 0x1f, 0x17, 0x3a, 0xf8, 0xd7, 0x81, 0xee, 0x24, 0x4b, 0x92, 0x38, 0xdd, 0x55,
+	0x45, 0x77, 0x6a, 0x45, 0xbf, 0x02, 0x6f, 0x2c, 0xf7, 0x45, 0xc2, 0x53, 0x95, 0x53, 0x57, 0x3b,
+	0x38, 0x13, 0x84, 0x42, 0x17, 0x8b, 0x85, 0xae, 0x9b, 0xba, 0x46, 0x25, 0x24, 0xdf, 0x02, 0xac,
+	0x62, 0x19, 0x27, 0x5c, 0x71, 0x99, 0xd3, 0xd6, 0xa8, 0x79, 0xd3, 0xbf, 0xbb, 0xd2, 0x52, 0xed,
+	0x6d, 0xb7, 0xe7, 0x74, 0x94, 0x2a, 0x79, 0x62, 0xb5, 0xf3, 0xc3, 0xef, 0xe0, 0xc5, 0xa3, 0x34,
+	0xf6, 0xf6, 0x37, 0x7e, 0xb2, 0xda, 0x30, 0xc4, 0x0a, 0xfe, 0x11, 0x1f, 0x8a, 0xd2, 0xa5, 0x01,
+	0xdf, 0xb8, 0x5f, 0x3b, 0xc1, 0x3f, 0x0e, 0xf4, 0xed, 0x35, 0xeb, 0x23, 0xdf, 0x3e, 0x69, 0xac,
+	0x26, 0xdd, 0xfd, 0x58, 0x3a, 0x81, 0xd6, 0x26, 0xde, 0xe7, 0xb4, 0x69, 0xba, 0x8b, 0x31, 0xf9,
+	0x12, 0x3a, 0x5a, 0x50, 0x69, 0xe5, 0x42, 0x5b, 0xa9, 0x34, 0x32, 0x9b, 0x25, 0x23, 0xe8, 0x87,
+	0x3c, 0xdf, 0x4a, 0x71, 0x54, 0x22, 0x4b, 0xf5, 0xc4, 0x79, 0xac, 0x4e, 0xa1, 0xea, 0xfb, 0x3c,
+	0xde, 0x9b, 0x81, 0xf3, 0x98, 0x01, 0xc8, 0x2e, 0xff, 0x4c, 0xb9, 0xd4, 0xa3, 0xe6, 0x31, 0x03,
+	0x82, 0xff, 0x1c, 0xf0, 0xaa, 0x3b, 0x9e, 0x74, 0x81, 0x5a, 0x4f, 0xc7, 0xaa, 0xd1, 0x18, 0xe3,
+	0xe4, 0x32, 0xfe, 0x7b, 0x21, 0x24, 0xdf, 0xe9, 0xae, 0xf4, 0x58, 0x85, 0xd1, 0x75, 0xc8, 0xdf,
+	0xc7, 0xc5, 0x41, 0xe9, 0x57, 0xe2, 0xb1, 0x12, 0x62, 0x66, 0x15, 0x2b, 0xc5, 0x65, 0xa9, 0xba,
+	0x84, 0x38, 0x93, 0xbf, 0x60, 0x69, 0x73, 0xda, 0x31, 0x13, 0x6c, 0x10, 0xf2, 0x2b, 0xc9, 0xdf,
+	0x8b, 0x07, 0x2b, 0xda, 0x22, 0xec, 0xd4, 0x5c, 0xa4, 0xb4, 0x67, 0x3a, 0x35, 0x17, 0xa9, 0x66,
+	0xe2, 0x07, 0xea, 0x59, 0x26, 0x7e, 0x08, 0xfe, 0x72, 0x60, 0xf0, 0x83, 0xc8, 0x55, 0x26, 0x4f,
+	0x3f, 0x17, 0x5c, 0x9e, 0x9e, 0x34, 0x57, 0x3d, 0x78, 0xf7, 0xb9, 0x07, 0x7f, 0x09, 0xed, 0xb5,
+	0x48, 0xb7, 0xdc, 0x4e, 0x9f, 0x01, 0xba, 0xc4, 0xa9, 0x12, 0x07, 0xbb, 0x08, 0x0c, 0x40, 0xb9,
+	0x93, 0x83, 0xe0, 0xa9, 0xb2, 0xfe, 0x2c, 0xc2, 0xd3, 0x33, 0x91, 0x08, 0x65, 0x37, 0x80, 0x01,
+	0xc1, 0x8f, 0xf0, 0x89, 0xd5, 0xc7, 0xf8, 0x36, 0x93, 0x3b, 0x72, 0x5d, 0xee, 0x2a, 0x2d, 0xb1,
+	0x5f, 0xbe, 0x3b, 0x4d, 0x31, 0x9b, 0x3a, 0xb7, 0xd1, 0xad, 0xb5, 0xf1, 0xe5, 0xaf, 0xd0, 0xd6,
+	0xaa, 0x49, 0x1f, 0xba, 0xf7, 0x8b, 0x9f, 0x16, 0xcb, 0xb7, 0x0b, 0xbf, 0x81, 0x60, 0x15, 0x2d,
+	0xc2, 0xe9, 0xe2, 0x8d, 0xef, 0x20, 0x60, 0xf7, 0x8b, 0x05, 0x02, 0x97, 0x0c, 0xa0, 0x37, 0x59,
+	0xce, 0x57, 0xb3, 0x68, 0x13, 0xf9, 0x4d, 0xd2, 0x83, 0xd6, 0xf7, 0xe3, 0xe9, 0xcc, 0x6f, 0xe1,
+	0xa1, 0xcd, 0x74, 0x1e, 0x2d, 0xef, 0x37, 0x7e, 0x1b, 0xc1, 0x7a, 0xb3, 0x5c, 0xad, 0xa2, 0xd0,
+	0xef, 0xbc, 0x1c, 0x41, 0xc7, 0x6c, 0x00, 0x02, 0x18, 0x85, 0x78, 0xa4, 0x61, 0xe3, 0x88, 0x31,
+	0xdf, 0xb9, 0xfb, 0xdf, 0x85, 0x1e, 0x9b, 0x44, 0xe3, 0x3d, 0x5a, 0x36, 0x85, 0x95, 0x8a, 0x0c,
+	0xea, 0x2f, 0x72, 0xd8, 0xd5, 0x68, 0x1a, 0x06, 0x0d, 0xf2, 0x05, 0xb4, 0xde, 0xc6, 0x42, 0x91,
+	0x92, 0x1a, 0xd6, 0xed, 0x06, 0x0d, 0x72, 0x0d, 0xde, 0x1b, 0xae, 0xac, 0xeb, 0xe7, 0x0e, 0x7d,
+	0x0e, 0x2d, 0x5c, 0xa7, 0xe7, 0xbc, 0xe9, 0xa0, 0x59, 0xfe, 0x0d, 0x12, 0x40, 0x97, 0x15, 0x69,
+	0x2a, 0xd2, 0x3d, 0xa9, 0x25, 0x6a, 0x2a, 0x5e, 0x39, 0x24, 0x28, 0xb7, 0xdd, 0xe3, 0x4b, 0xcc,
+	0x36, 0xd4, 0x67, 0x5e, 0xc1, 0x60, 0x26, 0x72, 0x65, 0x5d, 0xe4, 0x1f, 0x7d, 0xcc, 0xaf, 0x1b,
+	0xc4, 0x5d, 0xa0, 0x7f, 0xf1, 0x1a, 0x5e, 0x98, 0x27, 0xf9, 0x8e, 0xdb, 0xd4, 0xa3, 0x4a, 0x3c,
+	0xf1, 0x33, 0xf2, 0x15, 0x74, 0xed, 0x44, 0x90, 0x4f, 0x75, 0xba, 0x3e, 0xbf, 0x43, 0x52, 0xa7,
+	0xcc, 0xc8, 0xe0, 0x55, 0xef, 0x3a, 0xfa, 0x8f, 0xef, 0xf5, 0x87, 0x01, 0x00, 0xee, 0xc4, 0x31,
+	0xf3, 0x05, 0x07, 0x00, 0x00,
 }
@@ -17,4 +17,5 @@ func (s *Status) Print() {
 	fmt.Printf("Stdout      %v \n", s.Stdout)
 	fmt.Printf("Stderr      %v \n", s.Stderr)
 	fmt.Printf("Error       %v \n", s.Error)
+	fmt.Printf("Signal      %v \n", s.Signal)
 }
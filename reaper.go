@@ -0,0 +1,63 @@
+// Copyright 2017-2023 Block, Inc.
+
+package rce
+
+import (
+	"log"
+	"time"
+)
+
+// reap runs reapOnce every ServerConfig.ReapInterval until the server is stopped.
+func (s *server) reap() {
+	interval := s.cfg.ReapInterval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopChan:
+			return
+		case <-ticker.C:
+		}
+		s.reapOnce()
+	}
+}
+
+// reapOnce removes done commands from the repo that finished more than
+// ServerConfig.ReapAfter ago, and stops running (or queued) commands that no
+// client has observed for ServerConfig.MaxUnobserved. Stopped commands are
+// reaped later like other done commands.
+func (s *server) reapOnce() {
+	for _, id := range s.repo.All() {
+		rceCmd := s.repo.Get(id)
+		if rceCmd == nil {
+			continue // reaped by Wait
+		}
+		select {
+		case <-rceCmd.Done():
+			if s.cfg.ReapAfter <= 0 {
+				continue
+			}
+			stopped := time.Unix(0, rceCmd.Status().StopTs)
+			if time.Since(stopped) < s.cfg.ReapAfter {
+				continue
+			}
+			log.Printf("cmd=%s: reaping command done for %s", id, time.Since(stopped).Round(time.Millisecond))
+			s.repo.Remove(id)
+			s.metrics.reaped(rceCmd.Name)
+		default:
+			if s.cfg.MaxUnobserved <= 0 || rceCmd.Stopped() {
+				continue
+			}
+			unobserved := rceCmd.Unobserved()
+			if unobserved < s.cfg.MaxUnobserved {
+				continue
+			}
+			log.Printf("cmd=%s: stopping command unobserved for %s", id, unobserved.Round(time.Millisecond))
+			rceCmd.Stop()
+			s.metrics.abandoned(rceCmd.Name)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+// Copyright 2017-2023 Block, Inc.
+
+package rce
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/square/rce-agent/pb"
+	context "golang.org/x/net/context"
+)
+
+// DefaultWaitDelay is the default RemoteCmd.WaitDelay.
+const DefaultWaitDelay = 10 * time.Second
+
+var (
+	// ErrAlreadyStarted is returned by RemoteCmd.Start if it was already called.
+	ErrAlreadyStarted = errors.New("remote command already started")
+
+	// ErrNotStarted is returned by RemoteCmd.Wait if Start was not called.
+	ErrNotStarted = errors.New("remote command not started")
+
+	// ErrAlreadyWaited is returned by RemoteCmd.Wait if it was already called.
+	ErrAlreadyWaited = errors.New("remote command Wait already called")
+
+	// ErrStdoutSet is returned by RemoteCmd.Output and CombinedOutput if Stdout
+	// is already set, and by CombinedOutput if Stderr is already set.
+	ErrStdoutSet = errors.New("remote command Stdout or Stderr already set")
+)
+
+// RemoteCmd is a command run on a remote agent, like exec.Cmd. It handles
+// starting, streaming output, and waiting for (reaping) the command. Make a
+// RemoteCmd with Command or CommandContext. A RemoteCmd cannot be reused.
+type RemoteCmd struct {
+	// Name and Args of the command. Args are the client arguments appended
+	// to the command exec line on the agent.
+	Name string
+	Args []string
+
+	// Parameters are the optional named parameters of the command.
+	Parameters map[string]string
+
+	// Timeout is an optional timeout, rounded up to seconds. See pb.Command.
+	Timeout time.Duration
+
+	// WaitDelay bounds the time that Wait spends stopping and reaping the
+	// command after the context is done, in case the command ignores the stop.
+	// If zero, DefaultWaitDelay is used. If the command is not reaped in time,
+	// the agent reaps it later (see ServerConfig.ReapAfter).
+	WaitDelay time.Duration
+
+	// Stdout and Stderr receive the command output line by line (with a newline)
+	// as it is produced. If both are the same writer, lines are written in the
+	// order received. If nil, output is only in the final Status.
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// ID is the command ID, set by Start.
+	ID string
+
+	// Status is the final status of the command, set by Wait.
+	Status *pb.Status
+
+	client     ContextClient
+	ctx        context.Context
+	streamDone chan error // nil if not streaming
+	waited     bool
+}
+
+// Command returns a RemoteCmd to run the named command with the given args on
+// the agent that the client is connected to.
+func Command(client ContextClient, name string, args ...string) *RemoteCmd {
+	return CommandContext(context.Background(), client, name, args...)
+}
+
+// CommandContext is like Command but includes a context. If the context is
+// done before the command completes, the command is stopped, and Wait reaps it
+// and returns the context error.
+func CommandContext(ctx context.Context, client ContextClient, name string, args ...string) *RemoteCmd {
+	return &RemoteCmd{
+		Name:   name,
+		Args:   args,
+		client: client,
+		ctx:    ctx,
+	}
+}
+
+// Start starts the command but does not wait for it to complete. If Start
+// returns nil, Wait must be called to reap the command on the agent.
+func (c *RemoteCmd) Start() error {
+	if c.ID != "" {
+		return ErrAlreadyStarted
+	}
+	id, err := c.client.StartCommandContext(c.ctx, &pb.Command{
+		Name:       c.Name,
+		Arguments:  c.Args,
+		Timeout:    int64((c.Timeout + time.Second - 1) / time.Second), // round up
+		Parameters: c.Parameters,
+	})
+	if err != nil {
+		return err
+	}
+	c.ID = id
+
+	if c.Stdout != nil || c.Stderr != nil {
+		c.streamDone = make(chan error, 1)
+		go func() {
+			c.streamDone <- c.client.StreamContext(c.ctx, id, c.write)
+		}()
+	}
+	return nil
+}
+
+func (c *RemoteCmd) write(out *pb.Output) {
+	w := c.Stdout
+	if out.Stream == pb.STREAM_STDERR {
+		w = c.Stderr
+	}
+	if w != nil {
+		io.WriteString(w, out.Line+"\n")
+	}
+}
+
+// Wait waits for the command to complete and all its output to be written to
+// Stdout and Stderr, then reaps the command and sets Status. The returned
+// error is nil if the command completed with exit code zero. If the command
+// failed, timed out, or was stopped, the error is an *ExitError. Other errors
+// are RPC or context errors.
+func (c *RemoteCmd) Wait() error {
+	if c.ID == "" {
+		return ErrNotStarted
+	}
+	if c.waited {
+		return ErrAlreadyWaited
+	}
+	c.waited = true
+
+	// Wait for output first because Stream fails once Wait reaps the command
+	var streamErr error
+	if c.streamDone != nil {
+		streamErr = <-c.streamDone
+	}
+
+	status, err := c.client.WaitContext(c.ctx, c.ID)
+	if err != nil {
+		if c.ctx.Err() == nil {
+			return err
+		}
+		// Context done: stop and reap the command, like exec.CommandContext
+		// kills the process, but only for up to WaitDelay
+		delay := c.WaitDelay
+		if delay <= 0 {
+			delay = DefaultWaitDelay
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), delay)
+		defer cancel()
+		c.client.StopContext(ctx, c.ID)
+		if status, err := c.client.WaitContext(ctx, c.ID); err == nil {
+			c.Status = status
+		}
+		return c.ctx.Err()
+	}
+	c.Status = status
+	if status.State != pb.STATE_COMPLETE {
+		return &ExitError{Status: status}
+	}
+	return streamErr
+}
+
+// Run starts the command and waits for it to complete. See Wait.
+func (c *RemoteCmd) Run() error {
+	if err := c.Start(); err != nil {
+		return err
+	}
+	return c.Wait()
+}
+
+// Output runs the command and returns its STDOUT. Stdout must not be set.
+// STDERR is in the final Status, including ExitError.Status.
+func (c *RemoteCmd) Output() ([]byte, error) {
+	if c.Stdout != nil {
+		return nil, ErrStdoutSet
+	}
+	var stdout bytes.Buffer
+	c.Stdout = &stdout
+	err := c.Run()
+	return stdout.Bytes(), err
+}
+
+// CombinedOutput runs the command and returns its STDOUT and STDERR lines in
+// the order received. Stdout and Stderr must not be set.
+func (c *RemoteCmd) CombinedOutput() ([]byte, error) {
+	if c.Stdout != nil || c.Stderr != nil {
+		return nil, ErrStdoutSet
+	}
+	var out bytes.Buffer
+	c.Stdout = &out
+	c.Stderr = &out
+	err := c.Run()
+	return out.Bytes(), err
+}
+
+// ExitError is returned by RemoteCmd.Wait when the command did not complete
+// successfully: its final state is FAIL, TIMEOUT, or STOPPED.
+type ExitError struct {
+	*pb.Status
+}
+
+func (e *ExitError) Error() string {
+	var msg string
+	switch e.State {
+	case pb.STATE_FAIL:
+		msg = fmt.Sprintf("exit status %d", e.Status.ExitCode)
+	case pb.STATE_TIMEOUT:
+		msg = "timeout"
+	case pb.STATE_STOPPED:
+		msg = "stopped"
+	default:
+		msg = e.State.String()
+	}
+	if e.Status.Signal != "" {
+		msg += " (" + e.Status.Signal + ")"
+	}
+	if e.Status.Error != "" {
+		msg += ": " + e.Status.Error
+	}
+	return fmt.Sprintf("command %s (%s): %s", e.Status.Name, e.Status.ID, msg)
+}
+
+// ExitCode returns the exit code of the command, or -1 if it was terminated
+// by a signal or did not run.
+func (e *ExitError) ExitCode() int {
+	return int(e.Status.ExitCode)
+}
@@ -0,0 +1,160 @@
+// Copyright 2017-2023 Block, Inc.
+
+package rce
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/square/rce-agent/cmd"
+)
+
+var (
+	// ErrTooManyCommands is returned by scheduler.submit when a command cannot
+	// run because ServerConfig.MaxConcurrent commands are already running and
+	// ServerConfig.QueueCommands is false.
+	ErrTooManyCommands = errors.New("too many commands running")
+
+	// ErrTooManyInstances is returned by scheduler.submit when a command cannot
+	// run because Spec.MaxConcurrent instances of the command are already
+	// running and ServerConfig.QueueCommands is false.
+	ErrTooManyInstances = errors.New("too many instances of command running")
+
+	// ErrLocked is returned by scheduler.submit when a command cannot run
+	// because another running command holds one of its Spec.Locks and
+	// ServerConfig.QueueCommands is false. The error is wrapped with the lock
+	// name and the ID of the command holding it.
+	ErrLocked = errors.New("lock held")
+)
+
+// scheduler starts commands within the global and per-command concurrency
+// limits and only when their locks are free. Commands that cannot start are
+// rejected or, if queue is true, queued and started in FIFO order when running
+// commands finish.
+type scheduler struct {
+	*sync.Mutex
+	max     int                 // ServerConfig.MaxConcurrent
+	queue   bool                // ServerConfig.QueueCommands
+	running int                 // total running commands
+	byName  map[string]int      // running commands by name
+	locks   map[string]*cmd.Cmd // held locks by name
+	waiting []job               // queued commands, FIFO
+}
+
+// job is a command and the spec limits that apply to it.
+type job struct {
+	cmd   *cmd.Cmd
+	max   int      // Spec.MaxConcurrent
+	locks []string // Spec.Locks
+}
+
+func newScheduler(max int, queue bool) *scheduler {
+	return &scheduler{
+		Mutex:   &sync.Mutex{},
+		max:     max,
+		queue:   queue,
+		byName:  map[string]int{},
+		locks:   map[string]*cmd.Cmd{},
+		waiting: []job{},
+	}
+}
+
+// submit starts the job command if it is within limits and its locks are free,
+// else queues it. It returns true if the command was queued, or an error if
+// the command cannot start and queueing is disabled.
+func (s *scheduler) submit(j job) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	c := j.cmd
+	if err := s.admit(j); err != nil && !s.queue {
+		return false, err
+	}
+	s.waiting = append(s.waiting, j)
+	s.next()
+	if n := len(s.waiting); n == 0 || s.waiting[n-1].cmd != c {
+		return false, nil // started
+	}
+	go s.cancel(c)
+	return true, nil
+}
+
+// admit returns nil if the job can start now, else the limit it exceeds or
+// the lock it needs. The caller must hold the lock.
+func (s *scheduler) admit(j job) error {
+	if s.max > 0 && s.running >= s.max {
+		return ErrTooManyCommands
+	}
+	if j.max > 0 && s.byName[j.cmd.Name] >= j.max {
+		return ErrTooManyInstances
+	}
+	for _, lock := range j.locks {
+		if holder, ok := s.locks[lock]; ok {
+			return fmt.Errorf("%w: %s by command %s", ErrLocked, lock, holder.Id)
+		}
+	}
+	return nil
+}
+
+// start starts the job and releases it when done. The caller must hold the lock.
+func (s *scheduler) start(j job) {
+	s.running++
+	s.byName[j.cmd.Name]++
+	for _, lock := range j.locks {
+		s.locks[lock] = j.cmd
+	}
+	j.cmd.Start()
+	go s.release(j)
+}
+
+// release waits for the job to finish, then starts queued jobs that are now
+// within limits.
+func (s *scheduler) release(j job) {
+	<-j.cmd.Done()
+	s.Lock()
+	defer s.Unlock()
+	s.running--
+	if s.byName[j.cmd.Name]--; s.byName[j.cmd.Name] == 0 {
+		delete(s.byName, j.cmd.Name)
+	}
+	for _, lock := range j.locks {
+		delete(s.locks, lock)
+	}
+	s.next()
+}
+
+// next starts queued jobs, in order, that are within limits and whose locks
+// are free. A job blocked by its per-command limit or locks does not block jobs
+// for other commands. The caller must hold the lock.
+func (s *scheduler) next() {
+	waiting := s.waiting[:0]
+	for _, j := range s.waiting {
+		if s.admit(j) == nil {
+			s.start(j)
+		} else {
+			waiting = append(waiting, j)
+		}
+	}
+	s.waiting = waiting
+}
+
+// cancel removes a queued command from the queue if it is stopped before it
+// is started.
+func (s *scheduler) cancel(c *cmd.Cmd) {
+	<-c.Done()
+	s.Lock()
+	defer s.Unlock()
+	for i, j := range s.waiting {
+		if j.cmd == c {
+			s.waiting = append(s.waiting[:i], s.waiting[i+1:]...)
+			return
+		}
+	}
+}
+
+// queued returns the number of queued commands.
+func (s *scheduler) queued() int {
+	s.Lock()
+	defer s.Unlock()
+	return len(s.waiting)
+}
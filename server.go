@@ -5,8 +5,14 @@ package rce
 import (
 	"crypto/tls"
 	"errors"
+	"fmt"
 	"log"
 	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/square/rce-agent/cmd"
 	pb "github.com/square/rce-agent/pb"
@@ -14,6 +20,9 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
 )
 
 var (
@@ -32,8 +41,20 @@ var (
 	// before starting the internal gRPC server. If this error occurs, there is a bug
 	// in ServerConfig validation code.
 	ErrCommandNotAllowed = errors.New("command not allowed")
+
+	// ErrInvalidServerConfigAdmins is returned by Server.StartServer() when
+	// ServerConfig.Admins has an invalid client identity string.
+	ErrInvalidServerConfigAdmins = errors.New("invalid ServerConfig: invalid client identity in Admins")
+
+	// ErrInvalidServerConfigHistory is returned by Server.StartServer() when
+	// ServerConfig.HistoryFile is set but neither ServerConfig.HistorySize nor
+	// ServerConfig.HistoryAge is set.
+	ErrInvalidServerConfigHistory = errors.New("invalid ServerConfig: HistoryFile requires HistorySize or HistoryAge")
 )
 
+// serviceName is the full gRPC service name for health checks.
+const serviceName = "rce.RCEAgent"
+
 // A Server executes a whitelist of commands when called by clients.
 type Server interface {
 	// Start the gRPC server, non-blocking.
@@ -42,6 +63,12 @@ type Server interface {
 	// Stop the gRPC server gracefully.
 	StopServer() error
 
+	// Reload replaces the allowed commands. The commands are validated first;
+	// if invalid, the current commands are kept and the error is returned.
+	// Running commands are not affected. The difference (added, removed, and
+	// changed commands) is logged.
+	Reload(cmd.Runnable) error
+
 	pb.RCEAgentServer
 }
 
@@ -75,6 +102,104 @@ type ServerConfig struct {
 	// Use TLSFiles.TLSConfig() to load TLS files and configure for server and
 	// client verification.
 	TLS *tls.Config
+
+	// MaxConcurrent limits the number of commands running at once, if greater
+	// than zero. Spec.MaxConcurrent limits the number of instances of one command.
+	// By default, there are no limits.
+	MaxConcurrent int
+
+	// QueueCommands queues commands that would exceed MaxConcurrent or
+	// Spec.MaxConcurrent, or that need a Spec.Locks lock held by a running command.
+	// Queued commands have state PENDING and are started in order as running
+	// commands finish. Queued commands can be stopped. By default, Start returns
+	// a ResourceExhausted error for commands over a limit, or an Aborted error
+	// naming the command holding the lock for commands that cannot acquire a lock.
+	QueueCommands bool
+
+	// Audit receives a record of every RPC call: client address and TLS identity,
+	// command, result, and final command status. Use NewFileAuditSink or
+	// NewSyslogAuditSink for JSON lines records. By default, there is no audit.
+	Audit AuditSink
+
+	// ACL restricts which commands each client can run, by client TLS identity.
+	// If set, Start returns a PermissionDenied error for commands the client is
+	// not allowed to run, and clients without TLS cannot run any commands.
+	// Unknown commands return the same error, unless the ACL allows the client
+	// to run a command by that name. ListCommands and History return only
+	// commands the client can run.
+	// Use LoadACL to load an ACL from a file. By default, any client can run
+	// any allowed command.
+	ACL ACL
+
+	// RestrictToOwner allows only the client that started a command, or an
+	// admin client, to wait for, get the status of, stream, or stop the command.
+	// Other clients get a PermissionDenied error, and Running and History
+	// return only the client's commands. The client is identified by its TLS certificate, so
+	// without TLS all clients are the same client. By default, any client can
+	// access any command.
+	RestrictToOwner bool
+
+	// Admins are client identity strings, like "cn:oncall", for clients that
+	// can access all commands when RestrictToOwner is true. See Identity.Matches.
+	Admins []string
+
+	// CommandsFile is the commands file to watch for changes. If set, the server
+	// reloads AllowedCommands from the file (see cmd.LoadCommands and Server.Reload)
+	// when its modification time changes, checking every WatchInterval. If the
+	// file is invalid, it is not reloaded and the error is logged.
+	CommandsFile string
+
+	// WatchInterval is how often to check CommandsFile for changes.
+	// The default is 5 seconds.
+	WatchInterval time.Duration
+
+	// MetricsAddr is the host:port listen address for an HTTP server that
+	// exposes server metrics in the Prometheus text format at /metrics:
+	// RPCs, commands started and finished, command duration, running, queued,
+	// and unreaped commands, and TLS handshake failures. By default, there is
+	// no metrics server.
+	MetricsAddr string
+
+	// Reflection registers the gRPC server reflection service so that tools like
+	// grpcurl can list and describe the RCEAgent service. The standard gRPC
+	// health service (grpc.health.v1.Health) is always registered.
+	Reflection bool
+
+	// ReapAfter is how long to keep done commands that no client has reaped by
+	// calling Wait. After this time, the server reaps (removes) them, so clients
+	// can no longer get their status. By default, done commands are kept until
+	// reaped by a client.
+	ReapAfter time.Duration
+
+	// MaxUnobserved is how long a command can run without a client observing it
+	// (by calling Start, Wait, GetStatus, or Stream). After this time, the server
+	// stops the command. By default, commands are never stopped for this reason.
+	MaxUnobserved time.Duration
+
+	// ReapInterval is how often to check ReapAfter and MaxUnobserved.
+	// The default is 10 seconds.
+	ReapInterval time.Duration
+
+	// HistorySize and HistoryAge bound the history of done commands returned
+	// by the History RPC: at most HistorySize records that stopped within
+	// HistoryAge. If both are zero (the default), there is no history and
+	// History returns a FailedPrecondition error.
+	HistorySize int
+	HistoryAge  time.Duration
+
+	// HistoryFile is an optional file to persist the history across restarts,
+	// as JSON lines. It requires HistorySize or HistoryAge. The file is created
+	// with mode 0600 if it does not exist.
+	HistoryFile string
+
+	// ServerOptions are additional gRPC server options, like grpc.MaxRecvMsgSize
+	// and grpc.KeepaliveEnforcementPolicy. Do not set grpc.Creds; use TLS.
+	ServerOptions []grpc.ServerOption
+
+	// UnaryInterceptors and StreamInterceptors are gRPC server interceptors,
+	// called in order after the server metrics interceptor.
+	UnaryInterceptors  []grpc.UnaryServerInterceptor
+	StreamInterceptors []grpc.StreamServerInterceptor
 }
 
 func NewServerWithConfig(cfg ServerConfig) Server {
@@ -84,19 +209,38 @@ func NewServerWithConfig(cfg ServerConfig) Server {
 	s := &server{
 		cfg: cfg,
 		// --
-		repo: cmd.NewRepo(),
+		repo:     cmd.NewRepo(),
+		sched:    newScheduler(cfg.MaxConcurrent, cfg.QueueCommands),
+		mux:      &sync.RWMutex{},
+		commands: cfg.AllowedCommands,
+		stopChan: make(chan struct{}),
+	}
+	s.metrics = newMetrics(s.repo, s.sched)
+	if cfg.HistorySize > 0 || cfg.HistoryAge > 0 {
+		s.history = newHistory(cfg.HistorySize, cfg.HistoryAge, cfg.HistoryFile)
 	}
 
 	// Create a gRPC server and register this agent a implementing the
 	// RCEAgentServer interface and protocol
-	var grpcServer *grpc.Server
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(append([]grpc.UnaryServerInterceptor{s.metrics.unaryInterceptor}, cfg.UnaryInterceptors...)...),
+		grpc.ChainStreamInterceptor(append([]grpc.StreamServerInterceptor{s.metrics.streamInterceptor}, cfg.StreamInterceptors...)...),
+	}
 	if cfg.TLS != nil {
-		opt := grpc.Creds(credentials.NewTLS(cfg.TLS))
-		grpcServer = grpc.NewServer(opt)
-	} else {
-		grpcServer = grpc.NewServer()
+		creds := tlsMetricsCreds{TransportCredentials: credentials.NewTLS(cfg.TLS), m: s.metrics}
+		opts = append(opts, grpc.Creds(creds))
+	}
+	opts = append(opts, cfg.ServerOptions...)
+	s.grpcServer = grpc.NewServer(opts...)
+
+	// Health is NOT_SERVING until StartServer, and again during StopServer
+	s.health = health.NewServer()
+	s.health.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	s.health.SetServingStatus(serviceName, healthpb.HealthCheckResponse_NOT_SERVING)
+	healthpb.RegisterHealthServer(s.grpcServer, s.health)
+	if cfg.Reflection {
+		reflection.Register(s.grpcServer)
 	}
-	s.grpcServer = grpcServer
 
 	return s
 }
@@ -105,8 +249,17 @@ func NewServerWithConfig(cfg ServerConfig) Server {
 type server struct {
 	cfg ServerConfig
 	// --
-	repo       cmd.Repo     // running commands
-	grpcServer *grpc.Server // gRPC server instance of this agent
+	repo       cmd.Repo   // running commands
+	sched      *scheduler // concurrency limits and queue
+	mux        *sync.RWMutex
+	commands   cmd.Runnable  // AllowedCommands or reloaded, guarded by mux
+	stopChan   chan struct{} // closed by StopServer to stop watching CommandsFile
+	stopOnce   sync.Once     // closes stopChan once
+	metrics    *metrics
+	history    *history       // done commands, if ServerConfig.HistorySize or HistoryAge
+	httpServer *http.Server   // metrics server, if ServerConfig.MetricsAddr
+	health     *health.Server // gRPC health service
+	grpcServer *grpc.Server   // gRPC server instance of this agent
 }
 
 // NewServer makes a new Server that listens on laddr and runs the whitelist
@@ -138,6 +291,26 @@ func (s *server) StartServer() error {
 		}
 	}
 
+	if err := s.cfg.ACL.Validate(); err != nil {
+		return err
+	}
+	if s.cfg.ACL != nil && s.cfg.TLS == nil {
+		log.Printf("WARNING: ACL without TLS denies all commands\n")
+	}
+	for _, admin := range s.cfg.Admins {
+		if !validClient(admin) {
+			return ErrInvalidServerConfigAdmins
+		}
+	}
+	if s.cfg.HistoryFile != "" && s.history == nil {
+		return ErrInvalidServerConfigHistory
+	}
+	if s.history != nil {
+		if err := s.history.load(); err != nil {
+			return err
+		}
+	}
+
 	// Register the RCEAgent service with the gRPC server.
 	pb.RegisterRCEAgentServer(s.grpcServer, s)
 
@@ -145,7 +318,31 @@ func (s *server) StartServer() error {
 	if err != nil {
 		return err
 	}
+	if s.cfg.MetricsAddr != "" {
+		metricsLis, err := net.Listen("tcp", s.cfg.MetricsAddr)
+		if err != nil {
+			lis.Close()
+			return err
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", s.metrics)
+		s.httpServer = &http.Server{Handler: mux}
+		go s.httpServer.Serve(metricsLis)
+		log.Printf("metrics server listening on %s", s.cfg.MetricsAddr)
+	}
 	go s.grpcServer.Serve(lis)
+	s.health.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	s.health.SetServingStatus(serviceName, healthpb.HealthCheckResponse_SERVING)
+	if s.cfg.CommandsFile != "" {
+		var lastMod time.Time
+		if fi, err := os.Stat(s.cfg.CommandsFile); err == nil {
+			lastMod = fi.ModTime()
+		}
+		go s.watch(lastMod)
+	}
+	if s.cfg.ReapAfter > 0 || s.cfg.MaxUnobserved > 0 {
+		go s.reap()
+	}
 	if s.cfg.TLS != nil {
 		log.Printf("secure server listening on %s", s.cfg.Addr)
 	} else {
@@ -155,103 +352,264 @@ func (s *server) StartServer() error {
 }
 
 func (s *server) StopServer() error {
+	s.stopOnce.Do(func() { close(s.stopChan) })
+	if s.httpServer != nil {
+		s.httpServer.Close()
+	}
+	s.health.Shutdown() // NOT_SERVING while draining
 	s.grpcServer.GracefulStop()
+	if s.history != nil {
+		s.history.close()
+	}
 	log.Printf("server stopped on %s", s.cfg.Addr)
 	return nil
 }
 
+func (s *server) Reload(commands cmd.Runnable) error {
+	if s.cfg.AllowAnyCommand {
+		return ErrInvalidServerConfigAllowAnyCommand
+	}
+	if len(commands) == 0 {
+		return cmd.ErrNoCommands
+	}
+	if err := commands.Validate(); err != nil {
+		return err
+	}
+
+	s.mux.Lock()
+	old := s.commands
+	s.commands = commands
+	s.mux.Unlock()
+
+	added, removed, changed := commands.Diff(old)
+	log.Printf("reloaded commands: added: %v removed: %v changed: %v", added, removed, changed)
+	return nil
+}
+
+// allowed returns the current allowed commands.
+func (s *server) allowed() cmd.Runnable {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.commands
+}
+
+// watch reloads ServerConfig.CommandsFile when its modification time changes
+// from lastMod until the server is stopped.
+func (s *server) watch(lastMod time.Time) {
+	interval := s.cfg.WatchInterval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	log.Printf("watching commands file %s", s.cfg.CommandsFile)
+	for {
+		select {
+		case <-s.stopChan:
+			return
+		case <-ticker.C:
+		}
+		fi, err := os.Stat(s.cfg.CommandsFile)
+		if err != nil {
+			log.Printf("error watching commands file: %s", err)
+			continue
+		}
+		if fi.ModTime().Equal(lastMod) {
+			continue
+		}
+		lastMod = fi.ModTime()
+		commands, err := cmd.LoadCommands(s.cfg.CommandsFile)
+		if err == nil {
+			err = s.Reload(commands)
+		}
+		if err != nil {
+			log.Printf("error reloading commands file %s: %s", s.cfg.CommandsFile, err)
+		}
+	}
+}
+
 // //////////////////////////////////////////////////////////////////////////
 // pb.RCEAgentServer interface methods
 // //////////////////////////////////////////////////////////////////////////
 
-func (s *server) Start(ctx context.Context, c *pb.Command) (*pb.ID, error) {
-	id := &pb.ID{} // @todo we return this on error, but should be "return nil, <err>"
-
+func (s *server) Start(ctx context.Context, c *pb.Command) (id *pb.ID, err error) {
 	var rceCmd *cmd.Cmd // from AllowedCommands or an arbitrary if AllowAnyCommand
 	var path string     // for logging below
-	if s.cfg.AllowedCommands != nil {
-		spec, err := s.cfg.AllowedCommands.FindByName(c.Name)
+	var sjob job        // Spec limits and locks for the scheduler
+	allowed := s.allowed()
+	defer func() {
+		if rceCmd == nil {
+			rceCmd = &cmd.Cmd{Name: c.Name, Args: c.Arguments}
+		}
+		s.audit(ctx, "Start", "", rceCmd, nil, err)
+	}()
+	if allowed != nil {
+		spec, err := allowed.FindByName(c.Name)
 		if err != nil {
 			log.Printf("unknown command: %s", c.Name)
-			return id, grpc.Errorf(codes.InvalidArgument, "unknown command: %s", c.Name)
+			return nil, s.unknownCommand(ctx, c.Name)
+		}
+		if err := s.authorize(ctx, spec); err != nil {
+			return nil, err
+		}
+		if err := spec.ValidateArgs(c.Arguments); err != nil {
+			log.Printf("invalid arguments: %s: %s", c.Name, err)
+			field := "Arguments"
+			var argErr *cmd.ArgError
+			if errors.As(err, &argErr) {
+				field = fmt.Sprintf("Arguments[%d]", argErr.Index)
+			}
+			return nil, statusError(codes.InvalidArgument, "INVALID_ARGUMENTS", map[string]string{"command": c.Name},
+				fmt.Sprintf("invalid arguments for command %s: %s", c.Name, err), badRequest(field, err))
 		}
-		// Append cmd request args to cmd spec args
-		rceCmd = cmd.NewCmd(spec, append(spec.Args(), c.Arguments...))
+		exec, err := spec.Expand(c.Parameters)
+		if err != nil {
+			log.Printf("invalid parameters: %s: %s", c.Name, err)
+			field := "Parameters"
+			var paramErr *cmd.ParamError
+			if errors.As(err, &paramErr) {
+				field = "Parameters." + paramErr.Name
+			}
+			return nil, statusError(codes.InvalidArgument, "INVALID_ARGUMENTS", map[string]string{"command": c.Name},
+				fmt.Sprintf("invalid parameters for command %s: %s", c.Name, err), badRequest(field, err))
+		}
+		// Append cmd request args to cmd spec args (with parameters)
+		args := make([]string, 0, len(exec)-1+len(c.Arguments))
+		args = append(args, exec[1:]...)
+		args = append(args, c.Arguments...)
+		rceCmd = cmd.NewCmd(spec, args)
 
 		path = spec.Path()
+		sjob = job{max: spec.MaxConcurrent, locks: spec.Locks}
 	} else if s.cfg.AllowAnyCommand {
 		// Make a spec for this arbitrary command
 		spec := cmd.Spec{
 			Name: c.Name, // any command, like "/usr/local/bin/gofmt"
 			Exec: append([]string{c.Name}, c.Arguments...),
 		}
+		if err := s.authorize(ctx, spec); err != nil {
+			return nil, err
+		}
 		rceCmd = cmd.NewCmd(spec, c.Arguments)
 
 		path = c.Name
 	} else {
-		return id, ErrCommandNotAllowed
+		return nil, ErrCommandNotAllowed
+	}
+
+	// Client can request a timeout, but it can't exceed the command's timeout
+	if c.Timeout > 0 {
+		timeout := time.Duration(c.Timeout) * time.Second
+		if rceCmd.Timeout == 0 || timeout < rceCmd.Timeout {
+			rceCmd.Timeout = timeout
+		}
 	}
 
+	_, owner := peerIdentity(ctx)
+	rceCmd.StartedBy = owner.String()
+
 	if err := s.repo.Add(rceCmd); err != nil {
 		// This should never happen
 		log.Printf("duplicate command: %+v", rceCmd)
-		return id, grpc.Errorf(codes.AlreadyExists, "duplicate command: %s", rceCmd.Id)
+		return nil, grpc.Errorf(codes.AlreadyExists, "duplicate command: %s", rceCmd.Id)
 	}
 
-	log.Printf("cmd=%s: start: %s path: %s args: %v", rceCmd.Id, c.Name, path, rceCmd.Args)
-	rceCmd.Cmd.Start()
-	id.ID = rceCmd.Id
-	return id, nil
+	sjob.cmd = rceCmd
+	queued, err := s.sched.submit(sjob)
+	if err != nil {
+		s.repo.Remove(rceCmd.Id)
+		log.Printf("cmd=%s: rejected: %s: %s", rceCmd.Id, c.Name, err)
+		code, reason := codes.ResourceExhausted, "TOO_MANY_COMMANDS"
+		switch {
+		case errors.Is(err, ErrTooManyInstances):
+			reason = "TOO_MANY_INSTANCES"
+		case errors.Is(err, ErrLocked):
+			code, reason = codes.Aborted, "LOCKED"
+		}
+		return nil, statusError(code, reason, map[string]string{"command": c.Name},
+			fmt.Sprintf("cannot start command %s: %s", c.Name, err))
+	}
+	go s.metrics.command(rceCmd)
+	if s.history != nil {
+		go s.history.record(rceCmd, owner)
+	}
+	if queued {
+		log.Printf("cmd=%s: queued: %s path: %s args: %v timeout: %s", rceCmd.Id, c.Name, path, rceCmd.Args, rceCmd.Timeout)
+	} else {
+		log.Printf("cmd=%s: start: %s path: %s args: %v timeout: %s", rceCmd.Id, c.Name, path, rceCmd.Args, rceCmd.Timeout)
+	}
+	return &pb.ID{ID: rceCmd.Id}, nil
 }
 
-func (s *server) Wait(ctx context.Context, id *pb.ID) (*pb.Status, error) {
+func (s *server) Wait(ctx context.Context, id *pb.ID) (status *pb.Status, err error) {
 	log.Printf("cmd=%s: wait", id.ID)
 	defer log.Printf("cmd=%s: wait return", id.ID)
 
 	cmd := s.repo.Get(id.ID)
+	defer func() { s.audit(ctx, "Wait", id.ID, cmd, status, err) }()
 	if cmd == nil {
 		return nil, notFound(id)
 	}
-	// Reap the command
-	defer s.repo.Remove(id.ID)
+	if err := s.checkOwner(ctx, cmd); err != nil {
+		return nil, err
+	}
+	defer cmd.Watch()()
 
 	// Wait for command or ctx to finish
 	select {
-	case <-cmd.Cmd.Done():
+	case <-cmd.Done():
 	case <-ctx.Done():
+		// Don't reap the command if still running so the client can wait again
+		return mapStatus(cmd), ctx.Err()
 	}
 
-	// Get final status of command and convert to pb.Status. If ctx was canceled
-	// and command still running, its status will indicate this and ctx.Err()
-	// below will return an error, else it will return nil.
-	return mapStatus(cmd), ctx.Err()
+	// Reap the command and return its final status
+	s.repo.Remove(id.ID)
+	return mapStatus(cmd), nil
 }
 
-func (s *server) GetStatus(ctx context.Context, id *pb.ID) (*pb.Status, error) {
+func (s *server) GetStatus(ctx context.Context, id *pb.ID) (status *pb.Status, err error) {
 	log.Printf("cmd=%s: status", id.ID)
 	cmd := s.repo.Get(id.ID)
+	defer func() { s.audit(ctx, "GetStatus", id.ID, cmd, status, err) }()
 	if cmd == nil {
 		return nil, notFound(id)
 	}
+	if err := s.checkOwner(ctx, cmd); err != nil {
+		return nil, err
+	}
+	cmd.Observe()
 	return mapStatus(cmd), nil
 }
 
-func (s *server) Stop(ctx context.Context, id *pb.ID) (*pb.Empty, error) {
+func (s *server) Stop(ctx context.Context, id *pb.ID) (_ *pb.Empty, err error) {
 	log.Printf("cmd=%s: stop", id.ID)
 
 	cmd := s.repo.Get(id.ID)
+	defer func() { s.audit(ctx, "Stop", id.ID, cmd, nil, err) }()
 	if cmd == nil {
 		return nil, notFound(id)
 	}
+	if err := s.checkOwner(ctx, cmd); err != nil {
+		return nil, err
+	}
 
-	cmd.Cmd.Stop()
+	cmd.Stop()
 
 	return &pb.Empty{}, nil
 }
 
-func (s *server) Running(empty *pb.Empty, stream pb.RCEAgent_RunningServer) error {
+func (s *server) Running(empty *pb.Empty, stream pb.RCEAgent_RunningServer) (err error) {
 	log.Println("list running")
+	defer func() { s.audit(stream.Context(), "Running", "", nil, nil, err) }()
 	for _, id := range s.repo.All() {
+		if s.cfg.RestrictToOwner {
+			cmd := s.repo.Get(id)
+			if cmd == nil || s.checkOwner(stream.Context(), cmd) != nil {
+				continue // reaped or not owner
+			}
+		}
 		if err := stream.Send(&pb.ID{ID: id}); err != nil {
 			return err
 		}
@@ -259,12 +617,202 @@ func (s *server) Running(empty *pb.Empty, stream pb.RCEAgent_RunningServer) erro
 	return nil
 }
 
+func (s *server) Stream(id *pb.ID, stream pb.RCEAgent_StreamServer) (err error) {
+	log.Printf("cmd=%s: stream", id.ID)
+	defer log.Printf("cmd=%s: stream return", id.ID)
+
+	cmd := s.repo.Get(id.ID)
+	defer func() { s.audit(stream.Context(), "Stream", id.ID, cmd, nil, err) }()
+	if cmd == nil {
+		return notFound(id)
+	}
+	if err := s.checkOwner(stream.Context(), cmd); err != nil {
+		return err
+	}
+	defer cmd.Watch()()
+
+	// Send all output so far, then wait for more until the command is done.
+	// The command can be reaped while streaming; we have our own reference.
+	i := 0
+	for {
+		lines, more, done := cmd.Output(i)
+		for _, line := range lines {
+			out := &pb.Output{Line: line.Text}
+			if line.Stderr {
+				out.Stream = pb.STREAM_STDERR
+			}
+			if err := stream.Send(out); err != nil {
+				return err
+			}
+		}
+		i += len(lines)
+		if done {
+			return nil
+		}
+		select {
+		case <-more:
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
+
+func (s *server) ListCommands(empty *pb.Empty, stream pb.RCEAgent_ListCommandsServer) (err error) {
+	log.Println("list commands")
+	defer func() { s.audit(stream.Context(), "ListCommands", "", nil, nil, err) }()
+	for _, spec := range s.allowed() {
+		if s.cfg.ACL != nil {
+			if _, id := peerIdentity(stream.Context()); !s.cfg.ACL.Allowed(id, spec) {
+				continue
+			}
+		}
+		if err := stream.Send(mapSpec(spec)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *server) DescribeCommand(ctx context.Context, c *pb.Command) (spec *pb.CommandSpec, err error) {
+	log.Printf("describe command: %s", c.Name)
+	defer func() { s.audit(ctx, "DescribeCommand", "", &cmd.Cmd{Name: c.Name}, nil, err) }()
+	cmdSpec, err := s.allowed().FindByName(c.Name)
+	if err != nil {
+		return nil, s.unknownCommand(ctx, c.Name)
+	}
+	if err := s.authorize(ctx, cmdSpec); err != nil {
+		return nil, err
+	}
+	return mapSpec(cmdSpec), nil
+}
+
+func (s *server) History(q *pb.HistoryQuery, stream pb.RCEAgent_HistoryServer) (err error) {
+	log.Printf("history: %+v", q)
+	defer func() { s.audit(stream.Context(), "History", "", &cmd.Cmd{Name: q.Name}, nil, err) }()
+	if s.history == nil {
+		return statusError(codes.FailedPrecondition, "HISTORY_DISABLED", nil, "history is not enabled")
+	}
+	_, id := peerIdentity(stream.Context())
+	commands := s.allowed()
+	keep := func(r historyRecord) bool {
+		if s.cfg.RestrictToOwner && !s.isOwner(id, r.Owner) {
+			return false
+		}
+		if s.cfg.ACL != nil {
+			spec, err := commands.FindByName(r.Status.Name)
+			if err != nil {
+				spec = cmd.Spec{Name: r.Status.Name} // no longer allowed
+			}
+			if !s.cfg.ACL.Allowed(id, spec) {
+				return false
+			}
+		}
+		return true
+	}
+	for _, r := range s.history.query(q, keep) {
+		if err := stream.Send(&pb.HistoryRecord{Status: r.Status, Owner: r.Owner}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// authorize returns a PermissionDenied error if ServerConfig.ACL is set and
+// does not allow the client to run the command.
+func (s *server) authorize(ctx context.Context, spec cmd.Spec) error {
+	if s.cfg.ACL == nil {
+		return nil
+	}
+	addr, id := peerIdentity(ctx)
+	if !s.cfg.ACL.Allowed(id, spec) {
+		log.Printf("permission denied: %s: client %s %+v", spec.Name, addr, id)
+		return statusError(codes.PermissionDenied, "PERMISSION_DENIED", map[string]string{"command": spec.Name},
+			"permission denied: command "+spec.Name)
+	}
+	return nil
+}
+
+// checkOwner returns a PermissionDenied error if ServerConfig.RestrictToOwner
+// is true and the client is neither the command owner nor an admin.
+func (s *server) checkOwner(ctx context.Context, rceCmd *cmd.Cmd) error {
+	if !s.cfg.RestrictToOwner {
+		return nil
+	}
+	addr, id := peerIdentity(ctx)
+	if s.isOwner(id, rceCmd.StartedBy) {
+		return nil
+	}
+	log.Printf("cmd=%s: permission denied: client %s %s is not owner %s", rceCmd.Id, addr, id, rceCmd.StartedBy)
+	return statusError(codes.PermissionDenied, "PERMISSION_DENIED", map[string]string{"id": rceCmd.Id, "command": rceCmd.Name},
+		"permission denied: command ID "+rceCmd.Id)
+}
+
+// isOwner returns true if the client identity is the owner or an admin.
+func (s *server) isOwner(id *Identity, owner string) bool {
+	if id.String() == owner {
+		return true
+	}
+	for _, admin := range s.cfg.Admins {
+		if id.Matches(admin) {
+			return true
+		}
+	}
+	return false
+}
+
 func notFound(id *pb.ID) error {
-	return grpc.Errorf(codes.NotFound, "command ID %s not found", id.ID)
+	return statusError(codes.NotFound, "COMMAND_NOT_FOUND", map[string]string{"id": id.ID},
+		fmt.Sprintf("command ID %s not found", id.ID))
+}
+
+// unknownCommand returns an error for a command name that is not allowed. If
+// ServerConfig.ACL is set and does not allow the client to run a command by that
+// name, it returns the same PermissionDenied error as authorize so that clients
+// cannot tell unknown commands from commands they are not allowed to run.
+func (s *server) unknownCommand(ctx context.Context, name string) error {
+	if err := s.authorize(ctx, cmd.Spec{Name: name}); err != nil {
+		return err
+	}
+	return statusError(codes.InvalidArgument, "UNKNOWN_COMMAND", map[string]string{"command": name}, "unknown command: "+name)
+}
+
+func mapSpec(spec cmd.Spec) *pb.CommandSpec {
+	pbSpec := &pb.CommandSpec{
+		Name:        spec.Name,
+		Timeout:     int64((spec.Timeout + time.Second - 1) / time.Second), // round up
+		Tags:        spec.Tags,
+		Description: spec.Description,
+		Usage:       spec.Usage,
+		Owner:       spec.Owner,
+	}
+	for _, p := range spec.Params {
+		param := &pb.Parameter{
+			Name:     p.Name,
+			Type:     p.Type,
+			Required: p.Default == nil,
+			Pattern:  p.Pattern,
+			Values:   p.Values,
+			Prefix:   p.Prefix,
+		}
+		if param.Type == "" {
+			param.Type = cmd.ParamString
+		}
+		if p.Default != nil {
+			param.Default = *p.Default
+		}
+		if p.Min != nil {
+			param.Min = strconv.FormatInt(*p.Min, 10)
+		}
+		if p.Max != nil {
+			param.Max = strconv.FormatInt(*p.Max, 10)
+		}
+		pbSpec.Params = append(pbSpec.Params, param)
+	}
+	return pbSpec
 }
 
-func mapStatus(cmd *cmd.Cmd) *pb.Status {
-	cmdStatus := cmd.Cmd.Status()
+func mapStatus(rceCmd *cmd.Cmd) *pb.Status {
+	cmdStatus := rceCmd.Status()
 
 	var errMsg string
 	if cmdStatus.Error != nil {
@@ -273,16 +821,17 @@ func mapStatus(cmd *cmd.Cmd) *pb.Status {
 
 	// Make a pb.Status struct by adding and mapping some fields
 	pbStatus := &pb.Status{
-		ID:        cmd.Id,                // add
-		Name:      cmd.Name,              // add
+		ID:        rceCmd.Id,             // add
+		Name:      rceCmd.Name,           // add
 		ExitCode:  int64(cmdStatus.Exit), // map
 		Error:     errMsg,                // map
 		PID:       int64(cmdStatus.PID),  // map
 		StartTime: cmdStatus.StartTs,     // map
 		StopTime:  cmdStatus.StopTs,      // map
-		Args:      cmd.Args,              // map
+		Args:      rceCmd.Args,           // map
 		Stdout:    cmdStatus.Stdout,      // same
 		Stderr:    cmdStatus.Stderr,      // same
+		Signal:    cmd.SignalName(rceCmd.Signal()),
 	}
 
 	// Map go-cmd status to pb state
@@ -291,6 +840,10 @@ func mapStatus(cmd *cmd.Cmd) *pb.Status {
 		pbStatus.State = pb.STATE_PENDING
 	case cmdStatus.StartTs > 0 && cmdStatus.StopTs == 0:
 		pbStatus.State = pb.STATE_RUNNING
+	case cmdStatus.StopTs > 0 && rceCmd.TimedOut():
+		pbStatus.State = pb.STATE_TIMEOUT
+	case cmdStatus.StopTs > 0 && rceCmd.Stopped():
+		pbStatus.State = pb.STATE_STOPPED
 	case cmdStatus.StopTs > 0 && cmdStatus.Exit == 0:
 		pbStatus.State = pb.STATE_COMPLETE
 	case cmdStatus.StopTs > 0 && cmdStatus.Exit != 0:
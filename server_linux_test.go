@@ -0,0 +1,56 @@
+// Copyright 2017-2023 Block, Inc.
+
+package rce_test
+
+import (
+	"context"
+	"syscall"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/square/rce-agent"
+	"github.com/square/rce-agent/cmd"
+	"github.com/square/rce-agent/pb"
+)
+
+func TestServerProcAttr(t *testing.T) {
+	umask := 0027
+	commands := cmd.Runnable{
+		{
+			Name:        "proc-attr",
+			Exec:        []string{"/bin/bash", "-c", "pwd; umask; nice; ionice"},
+			Dir:         "/tmp",
+			Umask:       &umask,
+			Nice:        5,
+			IONiceClass: cmd.IONiceIdle,
+		},
+	}
+	if err := commands.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	s := rce.NewServer(LADDR, nil, commands)
+
+	id, err := s.Start(context.TODO(), &pb.Command{Name: "proc-attr"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotStatus, err := s.Wait(context.TODO(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotStatus.State != pb.STATE_COMPLETE {
+		t.Fatalf("got State %s, expected COMPLETE: %+v", gotStatus.State, gotStatus)
+	}
+
+	expect := []string{"/tmp", "0027", "5", "idle"}
+	if diff := deep.Equal(gotStatus.Stdout, expect); diff != nil {
+		t.Error(diff)
+	}
+
+	// The agent's attributes must not change
+	if mask := syscall.Umask(0); mask != 0027 {
+		syscall.Umask(mask)
+	} else {
+		t.Error("agent umask changed to 0027")
+	}
+}
@@ -3,17 +3,36 @@
 package rce_test
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
 	"os/exec"
+	"os/user"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/go-test/deep"
 	"github.com/square/rce-agent"
 	"github.com/square/rce-agent/cmd"
 	"github.com/square/rce-agent/pb"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/peer"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
 	"google.golang.org/grpc/status"
 )
 
@@ -180,8 +199,11 @@ func TestServerTLS(t *testing.T) {
 
 	id, gotErr := c.Start("nonexistent-cmd", []string{})
 	expectErr := grpc.Errorf(codes.InvalidArgument, "unknown command: nonexistent-cmd")
-	if diff := deep.Equal(gotErr, expectErr); diff != nil {
-		t.Error(diff)
+	if gotErr == nil || gotErr.Error() != expectErr.Error() {
+		t.Errorf("got error %v, expected %v", gotErr, expectErr)
+	}
+	if !errors.Is(gotErr, rce.ErrUnknownCommand) {
+		t.Errorf("got error %v, expected rce.ErrUnknownCommand", gotErr)
 	}
 	if id != "" {
 		t.Errorf("got id '%s', expected empty string", id)
@@ -344,3 +366,1281 @@ func TestServerAnyCommand(t *testing.T) {
 		t.Errorf("stdout = '%s', expected '%s'", gotStatus.Stdout[0], string(gover))
 	}
 }
+
+func TestServerTimeout(t *testing.T) {
+	s := rce.NewServer(LADDR, nil, whitelist)
+
+	// Command timeout (1s) from the whitelist
+	id, err := s.Start(context.TODO(), &pb.Command{Name: "sleep60.timeout"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotStatus, err := s.Wait(context.TODO(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotStatus.State != pb.STATE_TIMEOUT {
+		t.Errorf("got State %s, expected TIMEOUT", gotStatus.State)
+	}
+	if runtime := time.Duration(gotStatus.StopTime - gotStatus.StartTime); runtime > 5*time.Second {
+		t.Errorf("command ran %s, expected it to be stopped after 1s", runtime)
+	}
+
+	// Client timeout (1s) for command without a timeout
+	id, err = s.Start(context.TODO(), &pb.Command{Name: "sleep60", Timeout: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotStatus, err = s.Wait(context.TODO(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotStatus.State != pb.STATE_TIMEOUT {
+		t.Errorf("got State %s, expected TIMEOUT", gotStatus.State)
+	}
+
+	// Client timeout doesn't affect a command that completes in time
+	id, err = s.Start(context.TODO(), &pb.Command{Name: "exit.zero", Timeout: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotStatus, err = s.Wait(context.TODO(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotStatus.State != pb.STATE_COMPLETE {
+		t.Errorf("got State %s, expected COMPLETE", gotStatus.State)
+	}
+}
+
+func TestServerEnv(t *testing.T) {
+	t.Setenv("RCE_TEST_INHERIT", "yes")
+	t.Setenv("RCE_TEST_SECRET", "no")
+
+	s := rce.NewServer(LADDR, nil, whitelist)
+
+	id, err := s.Start(context.TODO(), &pb.Command{Name: "env"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotStatus, err := s.Wait(context.TODO(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the inherited and explicit variables, not RCE_TEST_SECRET
+	expect := []string{"RCE_TEST_INHERIT=yes", "FOO=bar"}
+	if diff := deep.Equal(gotStatus.Stdout, expect); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestServerUser(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("agent must run as root to run commands as another user")
+	}
+	u, err := user.Lookup("nobody")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	commands := cmd.Runnable{
+		{Name: "id-user", Exec: []string{"/usr/bin/id", "-u"}, User: "nobody"},
+		{Name: "id-groups", Exec: []string{"/usr/bin/id", "-G"}, User: "nobody", Groups: []string{"0"}},
+	}
+	if err := commands.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	s := rce.NewServer(LADDR, nil, commands)
+
+	id, err := s.Start(context.TODO(), &pb.Command{Name: "id-user"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotStatus, err := s.Wait(context.TODO(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(gotStatus.Stdout, []string{u.Uid}); diff != nil {
+		t.Error(diff)
+	}
+
+	id, err = s.Start(context.TODO(), &pb.Command{Name: "id-groups"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotStatus, err = s.Wait(context.TODO(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(gotStatus.Stdout, []string{u.Gid + " 0"}); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestServerArgPolicy(t *testing.T) {
+	s := rce.NewServer(LADDR, nil, whitelist)
+
+	id, err := s.Start(context.TODO(), &pb.Command{Name: "echo.noargs", Arguments: []string{"--help"}})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("got error %v, expected codes.InvalidArgument", err)
+	}
+	if id != nil && id.ID != "" {
+		t.Errorf("got ID %s, expected none", id.ID)
+	}
+
+	id, err = s.Start(context.TODO(), &pb.Command{Name: "echo.noargs"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotStatus, err := s.Wait(context.TODO(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(gotStatus.Stdout, []string{"hello"}); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestServerMaxConcurrent(t *testing.T) {
+	s := rce.NewServer(LADDR, nil, whitelist)
+
+	// Per-command limit
+	id1, err := s.Start(context.TODO(), &pb.Command{Name: "sleep60.one"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Start(context.TODO(), &pb.Command{Name: "sleep60.one"})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("got error %v, expected codes.ResourceExhausted", err)
+	}
+
+	// Other commands are not limited
+	id2, err := s.Start(context.TODO(), &pb.Command{Name: "exit.zero"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Wait(context.TODO(), id2); err != nil {
+		t.Fatal(err)
+	}
+
+	// Instance slot is released when the command is done
+	if _, err := s.Stop(context.TODO(), id1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Wait(context.TODO(), id1); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond) // slot released in the background
+	id1, err = s.Start(context.TODO(), &pb.Command{Name: "sleep60.one"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Stop(context.TODO(), id1)
+	s.Wait(context.TODO(), id1)
+
+	// Global limit
+	s = rce.NewServerWithConfig(rce.ServerConfig{
+		Addr:            LADDR,
+		AllowedCommands: whitelist,
+		MaxConcurrent:   1,
+	})
+	id1, err = s.Start(context.TODO(), &pb.Command{Name: "sleep60"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Wait(context.TODO(), id1)
+	defer s.Stop(context.TODO(), id1)
+	_, err = s.Start(context.TODO(), &pb.Command{Name: "exit.zero"})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("got error %v, expected codes.ResourceExhausted", err)
+	}
+}
+
+func TestServerQueueCommands(t *testing.T) {
+	s := rce.NewServerWithConfig(rce.ServerConfig{
+		Addr:            LADDR,
+		AllowedCommands: whitelist,
+		MaxConcurrent:   1,
+		QueueCommands:   true,
+	})
+
+	id1, err := s.Start(context.TODO(), &pb.Command{Name: "sleep60"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id2, err := s.Start(context.TODO(), &pb.Command{Name: "exit.zero"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id3, err := s.Start(context.TODO(), &pb.Command{Name: "exit.zero"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []*pb.ID{id2, id3} {
+		gotStatus, err := s.GetStatus(context.TODO(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gotStatus.State != pb.STATE_PENDING {
+			t.Errorf("%s: got state %s, expected PENDING", id.ID, gotStatus.State)
+		}
+	}
+
+	// Stopping a queued command dequeues it
+	if _, err := s.Stop(context.TODO(), id3); err != nil {
+		t.Fatal(err)
+	}
+	gotStatus, err := s.Wait(context.TODO(), id3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotStatus.State != pb.STATE_STOPPED {
+		t.Errorf("got state %s, expected STOPPED", gotStatus.State)
+	}
+	if gotStatus.StartTime != 0 {
+		t.Errorf("got start time %d, expected 0", gotStatus.StartTime)
+	}
+
+	// Queued command runs when the running command is done
+	if _, err := s.Stop(context.TODO(), id1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Wait(context.TODO(), id1); err != nil {
+		t.Fatal(err)
+	}
+	gotStatus, err = s.Wait(context.TODO(), id2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotStatus.State != pb.STATE_COMPLETE {
+		t.Errorf("got state %s, expected COMPLETE", gotStatus.State)
+	}
+}
+
+func TestServerLocks(t *testing.T) {
+	s := rce.NewServer(LADDR, nil, whitelist)
+
+	id1, err := s.Start(context.TODO(), &pb.Command{Name: "sleep60.dpkg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Start(context.TODO(), &pb.Command{Name: "exit.zero.dpkg"})
+	if status.Code(err) != codes.Aborted {
+		t.Errorf("got error %v, expected codes.Aborted", err)
+	}
+	if !strings.Contains(status.Convert(err).Message(), id1.ID) {
+		t.Errorf("error %q does not contain holding command ID %s", err, id1.ID)
+	}
+
+	// Commands without the lock are not blocked
+	id2, err := s.Start(context.TODO(), &pb.Command{Name: "exit.zero"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Wait(context.TODO(), id2)
+
+	// Lock is released when the command is done
+	s.Stop(context.TODO(), id1)
+	s.Wait(context.TODO(), id1)
+	time.Sleep(100 * time.Millisecond) // lock released in the background
+	id2, err = s.Start(context.TODO(), &pb.Command{Name: "exit.zero.dpkg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Wait(context.TODO(), id2)
+
+	// Queued until the lock is released
+	s = rce.NewServerWithConfig(rce.ServerConfig{
+		Addr:            LADDR,
+		AllowedCommands: whitelist,
+		QueueCommands:   true,
+	})
+	id1, err = s.Start(context.TODO(), &pb.Command{Name: "sleep60.dpkg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id2, err = s.Start(context.TODO(), &pb.Command{Name: "exit.zero.dpkg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotStatus, err := s.GetStatus(context.TODO(), id2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotStatus.State != pb.STATE_PENDING {
+		t.Errorf("got state %s, expected PENDING", gotStatus.State)
+	}
+	s.Stop(context.TODO(), id1)
+	s.Wait(context.TODO(), id1)
+	gotStatus, err = s.Wait(context.TODO(), id2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotStatus.State != pb.STATE_COMPLETE {
+		t.Errorf("got state %s, expected COMPLETE", gotStatus.State)
+	}
+}
+
+type testAuditSink struct {
+	sync.Mutex
+	records []rce.AuditRecord
+}
+
+func (s *testAuditSink) Audit(r rce.AuditRecord) error {
+	s.Lock()
+	defer s.Unlock()
+	s.records = append(s.records, r)
+	return nil
+}
+
+func TestServerAudit(t *testing.T) {
+	tlsFiles := rce.TLSFiles{
+		CACert: "./test/tls/test_root_ca.crt",
+		Cert:   "./test/tls/test_server.crt",
+		Key:    "./test/tls/test_server.key",
+	}
+	tlsConfig, err := tlsFiles.TLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := &testAuditSink{}
+	s := rce.NewServerWithConfig(rce.ServerConfig{
+		Addr:            LADDR,
+		AllowedCommands: whitelist,
+		TLS:             tlsConfig,
+		Audit:           sink,
+	})
+	if err := s.StartServer(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.StopServer()
+
+	tlsFiles = rce.TLSFiles{
+		CACert: "./test/tls/test_root_ca.crt",
+		Cert:   "./test/tls/test_client.crt",
+		Key:    "./test/tls/test_client.key",
+	}
+	tlsConfig, err = tlsFiles.TLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := rce.NewClient(tlsConfig)
+	if err := c.Open(HOST, PORT); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	c.Start("nonexistent-cmd", []string{"a"})
+	id, err := c.Start("echo", []string{"hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Wait(id); err != nil {
+		t.Fatal(err)
+	}
+
+	sink.Lock()
+	defer sink.Unlock()
+	if len(sink.records) != 3 {
+		t.Fatalf("got %d audit records, expected 3: %+v", len(sink.records), sink.records)
+	}
+	for _, r := range sink.records {
+		if r.Peer == "" {
+			t.Errorf("%s: no peer address", r.Method)
+		}
+		if r.Identity == nil || r.Identity.CommonName != "test_server" {
+			t.Errorf("%s: got identity %+v, expected CN test_server", r.Method, r.Identity)
+		}
+	}
+
+	r := sink.records[0]
+	if r.Method != "Start" || r.Command != "nonexistent-cmd" || r.Code != "InvalidArgument" || r.Error == "" {
+		t.Errorf("got %+v, expected failed Start", r)
+	}
+	r = sink.records[1]
+	if r.Method != "Start" || r.CommandId != id || r.Code != "OK" {
+		t.Errorf("got %+v, expected Start %s", r, id)
+	}
+	if diff := deep.Equal(r.Args, []string{"hello"}); diff != nil {
+		t.Error(diff)
+	}
+	r = sink.records[2]
+	if r.Method != "Wait" || r.CommandId != id || r.State != "COMPLETE" || r.ExitCode == nil || *r.ExitCode != 0 {
+		t.Errorf("got %+v, expected Wait with exit code 0", r)
+	}
+}
+
+func TestJSONAuditSink(t *testing.T) {
+	buf := &bytes.Buffer{}
+	sink := rce.NewJSONAuditSink(buf)
+	for _, method := range []string{"Start", "Wait"} {
+		if err := sink.Audit(rce.AuditRecord{Method: method, Code: "OK"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, expected 2: %s", len(lines), buf.String())
+	}
+	var r rce.AuditRecord
+	if err := json.Unmarshal([]byte(lines[1]), &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Method != "Wait" {
+		t.Errorf("got method %s, expected Wait", r.Method)
+	}
+}
+
+func TestLoadACL(t *testing.T) {
+	acl, err := rce.LoadACL("./test/acl.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := rce.ACL{
+		{Clients: []string{"cn:test_server"}, Commands: []string{"echo"}, Tags: []string{"safe"}},
+		{Clients: []string{"cn:admin", "spiffe://example.org/admin"}, Commands: []string{"*"}},
+	}
+	if diff := deep.Equal(acl, expect); diff != nil {
+		t.Error(diff)
+	}
+
+	// Commands file without access rules
+	acl, err = rce.LoadACL("./test/server-test-commands.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acl != nil {
+		t.Errorf("got ACL %+v, expected nil", acl)
+	}
+
+	for _, rule := range []rce.ACLRule{
+		{Commands: []string{"*"}},
+		{Clients: []string{"cn:admin"}},
+		{Clients: []string{"admin"}, Commands: []string{"*"}},
+		{Clients: []string{"cn:"}, Commands: []string{"*"}},
+		{Clients: []string{"spiffe:admin"}, Commands: []string{"*"}},
+	} {
+		if err := (rce.ACL{rule}).Validate(); err != rce.ErrInvalidACL {
+			t.Errorf("%+v: got error %v, expected ErrInvalidACL", rule, err)
+		}
+	}
+}
+
+func TestACLAllowed(t *testing.T) {
+	acl, err := rce.LoadACL("./test/acl.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	echo := cmd.Spec{Name: "echo"}
+	safe := cmd.Spec{Name: "ls", Tags: []string{"safe"}}
+	other := cmd.Spec{Name: "rm"}
+
+	tests := []struct {
+		id     *rce.Identity
+		spec   cmd.Spec
+		expect bool
+	}{
+		{&rce.Identity{CommonName: "test_server"}, echo, true},
+		{&rce.Identity{CommonName: "test_server"}, safe, true},
+		{&rce.Identity{CommonName: "test_server"}, other, false},
+		{&rce.Identity{CommonName: "admin"}, other, true},
+		{&rce.Identity{URIs: []string{"spiffe://example.org/admin"}}, other, true},
+		{&rce.Identity{URIs: []string{"spiffe://example.org/ci"}}, echo, false},
+		{&rce.Identity{DNSNames: []string{"test_server"}}, echo, false},
+		{nil, echo, false},
+	}
+	for _, test := range tests {
+		if got := acl.Allowed(test.id, test.spec); got != test.expect {
+			t.Errorf("%+v %s: got %t, expected %t", test.id, test.spec.Name, got, test.expect)
+		}
+	}
+}
+
+func TestServerACL(t *testing.T) {
+	acl, err := rce.LoadACL("./test/acl.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsFiles := rce.TLSFiles{
+		CACert: "./test/tls/test_root_ca.crt",
+		Cert:   "./test/tls/test_server.crt",
+		Key:    "./test/tls/test_server.key",
+	}
+	tlsConfig, err := tlsFiles.TLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := rce.NewServerWithConfig(rce.ServerConfig{
+		Addr:            LADDR,
+		AllowedCommands: whitelist,
+		TLS:             tlsConfig,
+		ACL:             acl,
+	})
+	if err := s.StartServer(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.StopServer()
+
+	tlsFiles = rce.TLSFiles{
+		CACert: "./test/tls/test_root_ca.crt",
+		Cert:   "./test/tls/test_client.crt",
+		Key:    "./test/tls/test_client.key",
+	}
+	tlsConfig, err = tlsFiles.TLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := rce.NewClient(tlsConfig)
+	if err := c.Open(HOST, PORT); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// Allowed by name and by tag
+	for _, name := range []string{"echo", "echo.noargs"} {
+		id, err := c.Start(name, nil)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if _, err := c.Wait(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err = c.Start("exit.zero", nil)
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("got error %v, expected codes.PermissionDenied", err)
+	}
+
+	// Only allowed commands are listed and described
+	specs, err := c.Commands()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, spec := range specs {
+		names = append(names, spec.Name)
+	}
+	if diff := deep.Equal(names, []string{"echo", "echo.noargs"}); diff != nil {
+		t.Error(diff)
+	}
+	_, err = c.DescribeCommand("exit.zero")
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("got error %v, expected codes.PermissionDenied", err)
+	}
+
+	// Unknown commands are not distinguishable from commands not allowed
+	_, err = c.Start("nonexistent-cmd", nil)
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("got error %v, expected codes.PermissionDenied", err)
+	}
+	_, err = c.DescribeCommand("nonexistent-cmd")
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("got error %v, expected codes.PermissionDenied", err)
+	}
+
+	// No TLS identity, no commands
+	insecure := rce.NewServerWithConfig(rce.ServerConfig{
+		Addr:            LADDR,
+		AllowedCommands: whitelist,
+		ACL:             acl,
+	})
+	_, err = insecure.Start(context.TODO(), &pb.Command{Name: "echo"})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("got error %v, expected codes.PermissionDenied", err)
+	}
+}
+
+// tlsPeer returns a context with a gRPC peer that has a verified TLS client
+// certificate loaded from the file.
+func tlsPeer(t *testing.T, certFile string) context.Context {
+	t.Helper()
+	bytes, err := os.ReadFile(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(bytes)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return peer.NewContext(context.TODO(), &peer.Peer{
+		Addr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345},
+		AuthInfo: credentials.TLSInfo{
+			State: tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}},
+		},
+	})
+}
+
+type historyStream struct {
+	grpc.ServerStream
+	ctx   context.Context
+	names []string
+}
+
+func (s *historyStream) Context() context.Context { return s.ctx }
+
+func (s *historyStream) Send(r *pb.HistoryRecord) error {
+	s.names = append(s.names, r.Status.Name)
+	return nil
+}
+
+type runningStream struct {
+	grpc.ServerStream
+	ctx context.Context
+	ids []string
+}
+
+func (s *runningStream) Context() context.Context { return s.ctx }
+
+func (s *runningStream) Send(id *pb.ID) error {
+	s.ids = append(s.ids, id.ID)
+	return nil
+}
+
+func TestServerRestrictToOwner(t *testing.T) {
+	s := rce.NewServerWithConfig(rce.ServerConfig{
+		Addr:            LADDR,
+		AllowedCommands: whitelist,
+		RestrictToOwner: true,
+		Admins:          []string{"cn:test root ca"},
+	})
+
+	owner := tlsPeer(t, "./test/tls/test_client.crt")
+	admin := tlsPeer(t, "./test/tls/test_root_ca.crt")
+	other := context.TODO() // no identity
+
+	id, err := s.Start(owner, &pb.Command{Name: "sleep60"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Other client cannot access the command or see it running
+	if _, err := s.GetStatus(other, id); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("GetStatus: got error %v, expected codes.PermissionDenied", err)
+	}
+	if _, err := s.Stop(other, id); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Stop: got error %v, expected codes.PermissionDenied", err)
+	}
+	if _, err := s.Wait(other, id); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Wait: got error %v, expected codes.PermissionDenied", err)
+	}
+	stream := &runningStream{ctx: other}
+	if err := s.Running(&pb.Empty{}, stream); err != nil {
+		t.Fatal(err)
+	}
+	if len(stream.ids) != 0 {
+		t.Errorf("got running %v, expected none", stream.ids)
+	}
+
+	// Owner and admin can
+	for _, ctx := range []context.Context{owner, admin} {
+		if _, err := s.GetStatus(ctx, id); err != nil {
+			t.Error(err)
+		}
+		stream := &runningStream{ctx: ctx}
+		if err := s.Running(&pb.Empty{}, stream); err != nil {
+			t.Fatal(err)
+		}
+		if diff := deep.Equal(stream.ids, []string{id.ID}); diff != nil {
+			t.Error(diff)
+		}
+	}
+	if _, err := s.Stop(admin, id); err != nil {
+		t.Error(err)
+	}
+	gotStatus, err := s.Wait(owner, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotStatus.State != pb.STATE_STOPPED {
+		t.Errorf("got state %s, expected STOPPED", gotStatus.State)
+	}
+
+	// Admins must be valid client identities
+	s = rce.NewServerWithConfig(rce.ServerConfig{
+		Addr:            LADDR,
+		AllowedCommands: whitelist,
+		RestrictToOwner: true,
+		Admins:          []string{"test root ca"},
+	})
+	if err := s.StartServer(); err != rce.ErrInvalidServerConfigAdmins {
+		t.Errorf("got error %v, expected ErrInvalidServerConfigAdmins", err)
+	}
+}
+
+func TestServerReload(t *testing.T) {
+	s := rce.NewServer(LADDR, nil, whitelist)
+
+	// Running command is not affected by reload
+	id, err := s.Start(context.TODO(), &pb.Command{Name: "sleep60"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commands := cmd.Runnable{
+		{Name: "echo.reloaded", Exec: []string{"/bin/echo", "reloaded"}},
+	}
+	if err := s.Reload(commands); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.Start(context.TODO(), &pb.Command{Name: "echo"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("got error %v, expected codes.InvalidArgument", err)
+	}
+	id2, err := s.Start(context.TODO(), &pb.Command{Name: "echo.reloaded"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotStatus, err := s.Wait(context.TODO(), id2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(gotStatus.Stdout, []string{"reloaded"}); diff != nil {
+		t.Error(diff)
+	}
+
+	gotStatus, err = s.GetStatus(context.TODO(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotStatus.State != pb.STATE_RUNNING {
+		t.Errorf("got state %s, expected RUNNING", gotStatus.State)
+	}
+	s.Stop(context.TODO(), id)
+	s.Wait(context.TODO(), id)
+
+	// Invalid commands are not loaded
+	invalid := cmd.Runnable{{Name: "relative", Exec: []string{"echo"}}}
+	if err := s.Reload(invalid); err != cmd.ErrRelativePath {
+		t.Errorf("got error %v, expected cmd.ErrRelativePath", err)
+	}
+	if err := s.Reload(nil); err != cmd.ErrNoCommands {
+		t.Errorf("got error %v, expected cmd.ErrNoCommands", err)
+	}
+	if _, err := s.Start(context.TODO(), &pb.Command{Name: "echo.reloaded"}); err != nil {
+		t.Errorf("got error %v after invalid reload, expected commands kept", err)
+	}
+}
+
+func TestServerWatchCommandsFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "commands.yaml")
+	write := func(name string, mtime time.Time) {
+		yaml := "commands:\n  - name: " + name + "\n    exec: [/bin/echo, " + name + "]\n"
+		if err := os.WriteFile(file, []byte(yaml), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(file, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("one", time.Now().Add(-time.Hour))
+	commands, err := cmd.LoadCommands(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := rce.NewServerWithConfig(rce.ServerConfig{
+		Addr:            LADDR,
+		AllowedCommands: commands,
+		CommandsFile:    file,
+		WatchInterval:   20 * time.Millisecond,
+	})
+	if err := s.StartServer(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.StopServer()
+
+	write("two", time.Now())
+	var id *pb.ID
+	for i := 0; i < 50; i++ {
+		time.Sleep(20 * time.Millisecond)
+		if id, err = s.Start(context.TODO(), &pb.Command{Name: "two"}); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatalf("command not reloaded: %s", err)
+	}
+	s.Wait(context.TODO(), id)
+
+	if _, err := s.Start(context.TODO(), &pb.Command{Name: "one"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("got error %v, expected codes.InvalidArgument", err)
+	}
+}
+
+func TestServerMetrics(t *testing.T) {
+	tlsFiles := rce.TLSFiles{
+		CACert: "./test/tls/test_root_ca.crt",
+		Cert:   "./test/tls/test_server.crt",
+		Key:    "./test/tls/test_server.key",
+	}
+	tlsConfig, err := tlsFiles.TLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := rce.NewServerWithConfig(rce.ServerConfig{
+		Addr:            LADDR,
+		AllowedCommands: whitelist,
+		TLS:             tlsConfig,
+		MetricsAddr:     "127.0.0.1:5502",
+	})
+	if err := s.StartServer(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.StopServer()
+
+	tlsFiles = rce.TLSFiles{
+		CACert: "./test/tls/test_root_ca.crt",
+		Cert:   "./test/tls/test_client.crt",
+		Key:    "./test/tls/test_client.key",
+	}
+	tlsConfig, err = tlsFiles.TLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := rce.NewClient(tlsConfig)
+	if err := c.Open(HOST, PORT); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	id, err := c.Start("echo", []string{"hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Wait(id); err != nil {
+		t.Fatal(err)
+	}
+	c.Start("nonexistent-cmd", nil)
+	id, err = c.Start("exit.zero", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetStatus(id); err != nil {
+		t.Fatal(err)
+	}
+
+	// Client without TLS fails the TLS handshake
+	conn, err := net.Dial("tcp", LADDR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("not TLS\r\n\r\n"))
+	io.ReadAll(conn) // until server closes conn
+	conn.Close()
+
+	expect := []string{
+		`rce_rpcs_total{method="Start",code="OK"} 2`,
+		`rce_rpcs_total{method="Start",code="InvalidArgument"} 1`,
+		`rce_rpcs_total{method="Wait",code="OK"} 1`,
+		`rce_commands_started_total{command="echo"} 1`,
+		`rce_commands_finished_total{command="echo",state="COMPLETE"} 1`,
+		`rce_command_duration_seconds_bucket{command="echo",le="+Inf"} 1`,
+		`rce_command_duration_seconds_count{command="echo"} 1`,
+		`rce_commands_running 0`,
+		`rce_commands_queued 0`,
+		`rce_commands_unreaped 1`, // exit.zero
+		`# TYPE rce_command_duration_seconds histogram`,
+	}
+	var body string
+	for i := 0; i < 50; i++ {
+		resp, err := http.Get("http://127.0.0.1:5502/metrics")
+		if err != nil {
+			t.Fatal(err)
+		}
+		bytes, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		body = string(bytes)
+		if strings.Contains(body, "rce_commands_unreaped 1") && !strings.Contains(body, "rce_tls_handshake_failures_total 0") {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	for _, line := range expect {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("metrics do not contain %s:\n%s", line, body)
+		}
+	}
+	if strings.Contains(body, "rce_tls_handshake_failures_total 0") {
+		t.Errorf("no TLS handshake failures counted:\n%s", body)
+	}
+}
+
+func TestServerHealthAndReflection(t *testing.T) {
+	s := rce.NewServerWithConfig(rce.ServerConfig{
+		Addr:            LADDR,
+		AllowedCommands: whitelist,
+		Reflection:      true,
+	})
+	if err := s.StartServer(); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := grpc.Dial(LADDR, grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Health
+	hc := healthpb.NewHealthClient(conn)
+	for _, service := range []string{"", "rce.RCEAgent"} {
+		resp, err := hc.Check(context.TODO(), &healthpb.HealthCheckRequest{Service: service})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Status != healthpb.HealthCheckResponse_SERVING {
+			t.Errorf("service %q: got status %s, expected SERVING", service, resp.Status)
+		}
+	}
+
+	// Reflection
+	rc, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rc.Send(&reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := rc.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	services := []string{}
+	for _, svc := range resp.GetListServicesResponse().GetService() {
+		services = append(services, svc.Name)
+	}
+	if !strings.Contains(strings.Join(services, " "), "rce.RCEAgent") {
+		t.Errorf("reflection services %v do not include rce.RCEAgent", services)
+	}
+	err = rc.Send(&reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: "rce.RCEAgent"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err = rc.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.GetFileDescriptorResponse().GetFileDescriptorProto()) == 0 {
+		t.Errorf("no file descriptor for rce.RCEAgent: %v", resp)
+	}
+	rc.CloseSend()
+
+	// NOT_SERVING while draining
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	watch, err := hc.Watch(ctx, &healthpb.HealthCheckRequest{Service: "rce.RCEAgent"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := watch.Recv(); err != nil || resp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Fatalf("got %v, %v, expected SERVING", resp, err)
+	}
+	stopped := make(chan struct{})
+	go func() {
+		s.StopServer()
+		close(stopped)
+	}()
+	if resp, err := watch.Recv(); err != nil || resp.Status != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("got %v, %v, expected NOT_SERVING", resp, err)
+	}
+	cancel() // end the watch so the server can stop
+	<-stopped
+}
+
+func TestServerReapAfter(t *testing.T) {
+	s := rce.NewServerWithConfig(rce.ServerConfig{
+		Addr:            LADDR,
+		AllowedCommands: whitelist,
+		ReapAfter:       100 * time.Millisecond,
+		ReapInterval:    20 * time.Millisecond,
+	})
+	if err := s.StartServer(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.StopServer()
+
+	id, err := s.Start(context.TODO(), &pb.Command{Name: "exit.zero"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	gotStatus, err := s.GetStatus(context.TODO(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotStatus.State != pb.STATE_COMPLETE {
+		t.Errorf("got state %s, expected COMPLETE", gotStatus.State)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	_, err = s.GetStatus(context.TODO(), id)
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("got error %v, expected codes.NotFound (reaped)", err)
+	}
+}
+
+func TestServerMaxUnobserved(t *testing.T) {
+	s := rce.NewServerWithConfig(rce.ServerConfig{
+		Addr:            LADDR,
+		AllowedCommands: whitelist,
+		MaxUnobserved:   200 * time.Millisecond,
+		ReapInterval:    20 * time.Millisecond,
+	})
+	if err := s.StartServer(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.StopServer()
+
+	abandoned, err := s.Start(context.TODO(), &pb.Command{Name: "sleep60"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	observed, err := s.Start(context.TODO(), &pb.Command{Name: "sleep60"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Wait(context.TODO(), observed)
+	defer s.Stop(context.TODO(), observed)
+
+	for i := 0; i < 10; i++ {
+		time.Sleep(50 * time.Millisecond)
+		if _, err := s.GetStatus(context.TODO(), observed); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	gotStatus, err := s.Wait(context.TODO(), abandoned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotStatus.State != pb.STATE_STOPPED {
+		t.Errorf("abandoned: got state %s, expected STOPPED", gotStatus.State)
+	}
+	gotStatus, err = s.GetStatus(context.TODO(), observed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotStatus.State != pb.STATE_RUNNING {
+		t.Errorf("observed: got state %s, expected RUNNING", gotStatus.State)
+	}
+}
+
+func TestServerHistory(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "history.json")
+	s := rce.NewServerWithConfig(rce.ServerConfig{
+		Addr:            LADDR,
+		AllowedCommands: whitelist,
+		HistorySize:     3,
+		HistoryFile:     file,
+	})
+	if err := s.StartServer(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { s.StopServer() }() // restarted below
+
+	c := rce.NewClient(nil)
+	if err := c.Open(HOST, PORT); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// Four done commands, but only the last three are kept
+	for _, name := range []string{"exit.zero", "echo", "sleep60", "exit.zero"} {
+		id, err := c.Start(name, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name == "sleep60" {
+			if err := c.Stop(id); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := c.Wait(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(100 * time.Millisecond) // recorded after done
+
+	names := func(records []*pb.HistoryRecord) []string {
+		names := []string{}
+		for _, r := range records {
+			names = append(names, r.Status.Name)
+		}
+		return names
+	}
+
+	all, err := c.History(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(names(all), []string{"echo", "sleep60", "exit.zero"}); diff != nil {
+		t.Fatal(diff)
+	}
+	stopTime := all[1].Status.StopTime
+
+	tests := []struct {
+		query  *pb.HistoryQuery
+		expect []string
+	}{
+		{&pb.HistoryQuery{Name: "exit.zero"}, []string{"exit.zero"}},
+		{&pb.HistoryQuery{State: pb.STATE_STOPPED}, []string{"sleep60"}},
+		{&pb.HistoryQuery{State: pb.STATE_COMPLETE}, []string{"echo", "exit.zero"}},
+		{&pb.HistoryQuery{Since: stopTime}, []string{"sleep60", "exit.zero"}},
+		{&pb.HistoryQuery{Until: stopTime}, []string{"echo"}},
+		{&pb.HistoryQuery{Limit: 1}, []string{"exit.zero"}},
+		{&pb.HistoryQuery{Client: "cn:test_server"}, []string{}}, // insecure
+	}
+	for _, test := range tests {
+		records, err := c.History(test.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := deep.Equal(names(records), test.expect); diff != nil {
+			t.Errorf("%+v: %v", test.query, diff)
+		}
+	}
+
+	// Restart with a smaller history: the file is loaded and rewritten
+	c.Close()
+	s.StopServer()
+	s = rce.NewServerWithConfig(rce.ServerConfig{
+		Addr:            LADDR,
+		AllowedCommands: whitelist,
+		HistorySize:     2,
+		HistoryFile:     file,
+	})
+	if err := s.StartServer(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Open(HOST, PORT); err != nil {
+		t.Fatal(err)
+	}
+	all, err = c.History(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(names(all), []string{"sleep60", "exit.zero"}); diff != nil {
+		t.Error(diff)
+	}
+	bytes, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(bytes), "\n"); n != 2 {
+		t.Errorf("got %d records in history file, expected 2", n)
+	}
+
+	// HistoryFile requires HistorySize or HistoryAge
+	s2 := rce.NewServerWithConfig(rce.ServerConfig{
+		Addr:            LADDR,
+		AllowedCommands: whitelist,
+		HistoryFile:     file,
+	})
+	if err := s2.StartServer(); err != rce.ErrInvalidServerConfigHistory {
+		t.Errorf("got error %v, expected ErrInvalidServerConfigHistory", err)
+	}
+}
+
+func TestServerHistoryAccess(t *testing.T) {
+	client := tlsPeer(t, "./test/tls/test_client.crt") // cn:test_server
+	admin := tlsPeer(t, "./test/tls/test_root_ca.crt")
+
+	run := func(s rce.Server, ctx context.Context, names ...string) {
+		t.Helper()
+		for _, name := range names {
+			id, err := s.Start(ctx, &pb.Command{Name: name})
+			if err != nil {
+				t.Fatalf("%s: %s", name, err)
+			}
+			if _, err := s.Wait(ctx, id); err != nil {
+				t.Fatal(err)
+			}
+		}
+		time.Sleep(100 * time.Millisecond) // recorded after done
+	}
+	history := func(s rce.Server, ctx context.Context, q *pb.HistoryQuery) []string {
+		t.Helper()
+		stream := &historyStream{ctx: ctx, names: []string{}}
+		if err := s.History(q, stream); err != nil {
+			t.Fatal(err)
+		}
+		return stream.names
+	}
+
+	// Limit applies to the client's commands, not all commands
+	s := rce.NewServerWithConfig(rce.ServerConfig{
+		Addr:            LADDR,
+		AllowedCommands: whitelist,
+		HistorySize:     10,
+		RestrictToOwner: true,
+	})
+	run(s, client, "echo")
+	run(s, admin, "exit.zero", "exit.zero")
+	if diff := deep.Equal(history(s, client, &pb.HistoryQuery{Limit: 1}), []string{"echo"}); diff != nil {
+		t.Error(diff)
+	}
+	if diff := deep.Equal(history(s, admin, &pb.HistoryQuery{}), []string{"exit.zero", "exit.zero"}); diff != nil {
+		t.Error(diff)
+	}
+
+	// Only commands the client is allowed to run
+	s = rce.NewServerWithConfig(rce.ServerConfig{
+		Addr:            LADDR,
+		AllowedCommands: whitelist,
+		HistorySize:     10,
+		ACL: rce.ACL{
+			{Clients: []string{"cn:test_server"}, Commands: []string{"echo"}},
+			{Clients: []string{"cn:test root ca"}, Commands: []string{"*"}},
+		},
+	})
+	run(s, admin, "echo", "exit.zero")
+	if diff := deep.Equal(history(s, client, &pb.HistoryQuery{}), []string{"echo"}); diff != nil {
+		t.Error(diff)
+	}
+	if diff := deep.Equal(history(s, admin, &pb.HistoryQuery{}), []string{"echo", "exit.zero"}); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestServerErrorDetails(t *testing.T) {
+	s := rce.NewServer(LADDR, nil, whitelist)
+
+	id, err := s.Start(context.TODO(), &pb.Command{Name: "nonexistent-cmd"})
+	if id != nil {
+		t.Errorf("got ID %+v, expected nil", id)
+	}
+	details := status.Convert(err).Details()
+	if len(details) != 1 {
+		t.Fatalf("got details %v, expected ErrorInfo", details)
+	}
+	info, ok := details[0].(*errdetails.ErrorInfo)
+	if !ok || info.Reason != "UNKNOWN_COMMAND" || info.Domain != "rce-agent" || info.Metadata["command"] != "nonexistent-cmd" {
+		t.Errorf("got detail %+v, expected UNKNOWN_COMMAND ErrorInfo", details[0])
+	}
+
+	id, err = s.Start(context.TODO(), &pb.Command{Name: "greet", Parameters: map[string]string{"name": "world", "count": "9"}})
+	if id != nil {
+		t.Errorf("got ID %+v, expected nil", id)
+	}
+	details = status.Convert(err).Details()
+	if len(details) != 2 {
+		t.Fatalf("got details %v, expected ErrorInfo and BadRequest", details)
+	}
+	badRequest, ok := details[1].(*errdetails.BadRequest)
+	if !ok || len(badRequest.FieldViolations) != 1 || badRequest.FieldViolations[0].Field != "Parameters.count" {
+		t.Errorf("got detail %+v, expected BadRequest for Parameters.count", details[1])
+	}
+}